@@ -1,7 +1,51 @@
 package common
 
 var (
-	JWT_TOKEN_NAME      = "jwttoken"
-	JWT_TOKEN_EXP_HOURS = 24
-	EMPTY_DB_STR        = "EMPTYSTRFIELD"
+	JWT_TOKEN_NAME          = "jwttoken"
+	JWT_TOKEN_EXP_MINUTES   = 15
+	REFRESH_TOKEN_NAME      = "refreshtoken"
+	REFRESH_TOKEN_EXP_HOURS = 24 * 30
+	RESET_TOKEN_EXP_MINUTES = 30
+	VERIFY_TOKEN_EXP_HOURS  = 48
+	MFA_TOKEN_EXP_MINUTES   = 5
+	MFA_MAX_ATTEMPTS        = 5
+	RECOVERY_CODES_COUNT    = 10
+	TOTP_ISSUER             = "Blog"
+	OIDC_STATE_EXP_MINUTES  = 10
+	OIDC_STATE_COOKIE_NAME  = "oidcstate"
+	API_TOKEN_PREFIX        = "blogpat_"
+	API_TOKEN_EXP_DAYS      = 30
+	API_TOKEN_MAX_EXP_DAYS  = 365
+	USERS_PAGE_LIMIT        = 20
+	PUBLISH_INTERVAL_SEC    = 30
+	EMPTY_DB_STR            = "EMPTYSTRFIELD"
+)
+
+var (
+	PASSWORD_MIN_LENGTH = 8
+	PASSWORD_MAX_LENGTH = 64
+	// Algorithm of new password hashes, bcrypt or argon2id, set from PASSWORD_HASHER env
+	PASSWORD_HASHER = "bcrypt"
+	// Cost of bcrypt hashes, set from BCRYPT_COST env
+	BCRYPT_COST = 10
+	// Parameters of argon2id hashes, set from ARGON2_MEMORY_KB, ARGON2_TIME and ARGON2_THREADS env
+	ARGON2_MEMORY_KB = 64 * 1024
+	ARGON2_TIME      = 3
+	ARGON2_THREADS   = 2
+)
+
+var (
+	LOGIN_FREE_ATTEMPTS         = 5
+	IP_FREE_ATTEMPTS            = 20
+	RESET_FREE_REQUESTS         = 3
+	RESET_IP_FREE_REQUESTS      = 10
+	LOCKOUT_MAX_MINUTES         = 15
+	LOGIN_FAILURES_WINDOW_HOURS = 24
+)
+
+var (
+	// Users with not verified email can't create content, set from REQUIRE_VERIFIED_EMAIL env
+	REQUIRE_VERIFIED_EMAIL = false
+	// Client ip is read from X-Forwarded-For header, set from TRUST_PROXY env
+	TRUST_PROXY = false
 )
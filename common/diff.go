@@ -0,0 +1,97 @@
+package common
+
+import "strings"
+
+/* diff.go file stores line-level diff of two texts. It is the longest
+   common subsequence of lines, texts which are too long to compare
+   are shown as fully replaced */
+
+const (
+	DiffEqual  = "equal"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// Limit of compared line pairs, it keeps memory of one diff under 16MB
+const maxDiffCells = 4 * 1024 * 1024
+
+// DiffLine is one line of the diff, which was kept, deleted or inserted
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffLines returns diff which turns text a into text b
+func DiffLines(a string, b string) []DiffLine {
+	from, to := splitLines(a), splitLines(b)
+
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	diff := make([]DiffLine, 0, len(from)+len(to))
+	diff = appendLines(diff, DiffEqual, from[:prefix])
+	diff = append(diff, diffMiddle(from[prefix:len(from)-suffix], to[prefix:len(to)-suffix])...)
+	diff = appendLines(diff, DiffEqual, from[len(from)-suffix:])
+	return diff
+}
+
+func diffMiddle(a []string, b []string) []DiffLine {
+	var diff []DiffLine
+	if len(a)*len(b) > maxDiffCells {
+		diff = appendLines(diff, DiffDelete, a)
+		return appendLines(diff, DiffInsert, b)
+	}
+
+	// lcs[i][j] is length of common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	diff = appendLines(diff, DiffDelete, a[i:])
+	return appendLines(diff, DiffInsert, b[j:])
+}
+
+func appendLines(diff []DiffLine, op string, lines []string) []DiffLine {
+	for _, line := range lines {
+		diff = append(diff, DiffLine{Op: op, Text: line})
+	}
+	return diff
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func eq(text string) DiffLine  { return DiffLine{Op: DiffEqual, Text: text} }
+func del(text string) DiffLine { return DiffLine{Op: DiffDelete, Text: text} }
+func ins(text string) DiffLine { return DiffLine{Op: DiffInsert, Text: text} }
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []DiffLine
+	}{
+		{name: "both empty", a: "", b: "", want: []DiffLine{}},
+		{name: "empty old text", a: "", b: "one\ntwo", want: []DiffLine{ins("one"), ins("two")}},
+		{name: "empty new text", a: "one\ntwo", b: "", want: []DiffLine{del("one"), del("two")}},
+		{name: "same text", a: "one\ntwo", b: "one\ntwo", want: []DiffLine{eq("one"), eq("two")}},
+		{name: "crlf is the same as lf", a: "one\r\ntwo\r\n", b: "one\ntwo\n", want: []DiffLine{eq("one"), eq("two"), eq("")}},
+		{name: "crlf with change", a: "one\r\ntwo", b: "one\r\nthree", want: []DiffLine{eq("one"), del("two"), ins("three")}},
+		{name: "added trailing newline", a: "one", b: "one\n", want: []DiffLine{eq("one"), ins("")}},
+		{
+			name: "shared prefix and suffix",
+			a:    "head\nold\ntail",
+			b:    "head\nnew\ntail",
+			want: []DiffLine{eq("head"), del("old"), ins("new"), eq("tail")},
+		},
+		{
+			name: "prefix and suffix overlap",
+			a:    "a\na",
+			b:    "a\na\na",
+			want: []DiffLine{eq("a"), eq("a"), ins("a")},
+		},
+		{
+			name: "common lines in the middle",
+			a:    "a\nb\nc\nd",
+			b:    "x\nb\ny\nd\nz",
+			want: []DiffLine{del("a"), ins("x"), eq("b"), del("c"), ins("y"), eq("d"), ins("z")},
+		},
+		{
+			name: "moved line",
+			a:    "one\ntwo\nthree",
+			b:    "two\nthree\none",
+			want: []DiffLine{del("one"), eq("two"), eq("three"), ins("one")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLines(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// applyDiff rebuilds both texts from diff
+func applyDiff(diff []DiffLine) (string, string) {
+	var from, to []string
+	for _, line := range diff {
+		if line.Op != DiffInsert {
+			from = append(from, line.Text)
+		}
+		if line.Op != DiffDelete {
+			to = append(to, line.Text)
+		}
+	}
+	return strings.Join(from, "\n"), strings.Join(to, "\n")
+}
+
+func numberedLines(prefix string, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s %d", prefix, i)
+	}
+	return lines
+}
+
+func TestDiffLinesFallsBackOverLimit(t *testing.T) {
+	// 2100*2100 line pairs are more than maxDiffCells
+	a := numberedLines("old", 2100)
+	b := numberedLines("new", 2100)
+	b[1000] = a[1000] // common line is not found by the fallback
+	a = append([]string{"head"}, append(a, "tail")...)
+	b = append([]string{"head"}, append(b, "tail")...)
+	if 2100*2100 <= maxDiffCells {
+		t.Fatal("texts are under the limit")
+	}
+
+	diff := DiffLines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	if len(diff) != 2+2100+2100 {
+		t.Fatalf("got %d lines, want %d", len(diff), 2+2100+2100)
+	}
+	if diff[0] != eq("head") || diff[len(diff)-1] != eq("tail") {
+		t.Fatalf("shared prefix and suffix are not kept: %v, %v", diff[0], diff[len(diff)-1])
+	}
+	for i, line := range diff[1 : len(diff)-1] {
+		want := DiffDelete
+		if i >= 2100 {
+			want = DiffInsert
+		}
+		if line.Op != want {
+			t.Fatalf("line %d: got %s, want %s", i+1, line.Op, want)
+		}
+	}
+
+	from, to := applyDiff(diff)
+	if from != strings.Join(a, "\n") || to != strings.Join(b, "\n") {
+		t.Fatal("diff doesn't rebuild texts")
+	}
+}
+
+func TestDiffLinesUnderLimitFindsCommonLines(t *testing.T) {
+	a := numberedLines("old", 100)
+	b := numberedLines("new", 100)
+	b[50] = a[50]
+
+	diff := DiffLines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+	equal := 0
+	for _, line := range diff {
+		if line.Op == DiffEqual {
+			equal++
+		}
+	}
+	if equal != 1 {
+		t.Fatalf("got %d equal lines, want 1", equal)
+	}
+	from, to := applyDiff(diff)
+	if from != strings.Join(a, "\n") || to != strings.Join(b, "\n") {
+		t.Fatal("diff doesn't rebuild texts")
+	}
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maxik12233/blog/types"
+)
+
+/* errors.go file stores application errors shared by all services
+   and the error encoder for http transports and middlewares. Every
+   error is returned to the client as RFC 7807 problem details */
+
+var ErrBadRequest = newAppError("bad_request", http.StatusBadRequest, "Bad request")
+var ErrInvalidId = newAppError("invalid_id", http.StatusNotFound, "Invalid id")
+var ErrNotFound = newAppError("not_found", http.StatusNotFound, "Not found")
+var ErrInternalError = newAppError("internal_error", http.StatusInternalServerError, "Internal error")
+var ErrInvalidLoginOrPassword = newAppError("invalid_credentials", http.StatusBadRequest, "Invalid login or password")
+var ErrAlreadyExists = newAppError("already_exists", http.StatusConflict, "Already exists")
+var ErrUnauthorized = newAppError("unauthorized", http.StatusUnauthorized, "Unauthorized")
+var ErrForbidden = newAppError("forbidden", http.StatusForbidden, "Forbidden")
+var ErrInvalidToken = newAppError("invalid_token", http.StatusBadRequest, "Invalid or expired token")
+var ErrInvalidCode = newAppError("invalid_code", http.StatusBadRequest, "Invalid code")
+var ErrWeakPassword = newAppError("weak_password", http.StatusBadRequest, "Password is too short, too long or too common")
+var ErrMethodNotAllowed = newAppError("method_not_allowed", http.StatusMethodNotAllowed, "Method not allowed")
+var ErrPreconditionFailed = newAppError("precondition_failed", http.StatusPreconditionFailed, "Resource was changed, get it again")
+var ErrPreconditionRequired = newAppError("precondition_required", http.StatusPreconditionRequired, "If-Match header is required")
+var ErrInvalidTransition = newAppError("invalid_transition", http.StatusConflict, "Resource can't be moved to this status")
+var ErrTooManyAttempts = newAppError("too_many_attempts", http.StatusTooManyRequests, "Too many attempts, try again later")
+
+// AppError is error which is shown to the client. Errors above are
+// compared by identity, errors with details wrap one of them
+type AppError struct {
+	Code       string // stable machine readable code
+	Status     int
+	Message    string
+	Details    []types.ErrorDetail
+	RetryAfter time.Duration
+	Err        error
+}
+
+func newAppError(code string, status int, message string) *AppError {
+	return &AppError{
+		Code:    code,
+		Status:  status,
+		Message: message,
+	}
+}
+
+func (e *AppError) Error() string {
+	return e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// NewValidationError returns ErrBadRequest with details about every invalid field
+func NewValidationError(details []types.ErrorDetail) *AppError {
+	return &AppError{
+		Code:    "validation_failed",
+		Status:  http.StatusBadRequest,
+		Message: "Validation failed",
+		Details: details,
+		Err:     ErrBadRequest,
+	}
+}
+
+// NewRetryError returns ErrTooManyAttempts which tells when client can try again
+func NewRetryError(after time.Duration) *AppError {
+	err := *ErrTooManyAttempts
+	err.RetryAfter = after
+	err.Err = ErrTooManyAttempts
+	return &err
+}
+
+// AsAppError finds AppError in err chain, unknown errors become
+// ErrInternalError so their text never reaches the client
+func AsAppError(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return ErrInternalError
+}
+
+// Problem returns body of the response with error
+func (e *AppError) Problem(requestid string) types.Problem {
+	return types.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Message,
+		Code:      e.Code,
+		RequestID: requestid,
+		Errors:    e.Details,
+	}
+}
+
+// EncodeError writes err as application/problem+json, it is used both
+// by go-kit servers and by middlewares
+func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	appErr := AsAppError(err)
+	if appErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((appErr.RetryAfter+time.Second-1)/time.Second)))
+	}
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.WriteHeader(appErr.Status)
+	_ = json.NewEncoder(w).Encode(appErr.Problem(RequestID(ctx)))
+}
+
+// RequestID returns id which is set by middleware.RequestID
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value("RequestID").(string)
+	return id
+}
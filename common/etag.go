@@ -0,0 +1,95 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* etag.go file stores conditional requests. Entity tag of the resource
+   is made of version columns, which are incremented on every update.
+   Updates must send If-Match with the tag they have read, so concurrent
+   update is rejected instead of being overwritten */
+
+// ETagged is response of one resource, its tag is sent in ETag header
+type ETagged interface {
+	ETag() string
+}
+
+// VersionTag returns strong entity tag of resource made of versions
+func VersionTag(versions ...uint) string {
+	parts := make([]string, 0, len(versions))
+	for _, version := range versions {
+		parts = append(parts, strconv.FormatUint(uint64(version), 10))
+	}
+	return `"` + strings.Join(parts, ".") + `"`
+}
+
+// IfMatch reads tags from If-Match header, which is required for updates
+func IfMatch(r *http.Request) ([]string, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, ErrPreconditionRequired
+	}
+	return parseTags(header), nil
+}
+
+// MatchTag tells if current tag satisfies If-Match tags. Weak tags
+// never match, because If-Match uses strong comparison
+func MatchTag(tags []string, current string) bool {
+	for _, tag := range tags {
+		if tag == "*" || (tag == current && !strings.HasPrefix(tag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PopulateIfNoneMatch puts If-None-Match of GET request into context,
+// it is used as ServerBefore option of go-kit servers
+func PopulateIfNoneMatch(ctx context.Context, r *http.Request) context.Context {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ctx
+	}
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, "IfNoneMatch", parseTags(header))
+}
+
+// WriteETag sets ETag header for tagged response. It returns true if
+// response wasn't modified since client got it, 304 is written then
+// and body must not be written
+func WriteETag(ctx context.Context, w http.ResponseWriter, response interface{}) bool {
+	tagged, ok := response.(ETagged)
+	if !ok {
+		return false
+	}
+	current := tagged.ETag()
+	if current == "" {
+		return false
+	}
+	w.Header().Set("ETag", current)
+
+	tags, _ := ctx.Value("IfNoneMatch").([]string)
+	for _, tag := range tags {
+		// If-None-Match uses weak comparison
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func parseTags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/* mergepatch.go file stores JSON Merge Patch (RFC 7396) which is used
+   for partial updates. Members of the patch replace members of the
+   document, objects are merged recursively and null removes member */
+
+// MergePatch applies patch to doc and returns patched document
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, ErrBadRequest
+	}
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	doc, ok := target.(map[string]interface{})
+	if !ok {
+		doc = make(map[string]interface{})
+	}
+	for key, val := range changes {
+		if val == nil {
+			delete(doc, key)
+			continue
+		}
+		doc[key] = mergePatch(doc[key], val)
+	}
+	return doc
+}
+
+// ApplyPatch applies patch to json of current and decodes the result into
+// patched, so fields removed by the patch get zero values
+func ApplyPatch(current interface{}, patch []byte, patched interface{}) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	merged, err := MergePatch(doc, patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(merged, patched); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return InvalidField(typeErr.Field)
+		}
+		return ErrBadRequest
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Cases of RFC 7396 appendix A and nested objects
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "replace member", doc: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add member", doc: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "null deletes member", doc: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{name: "null deletes only its member", doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{name: "null of missing member", doc: `{"a":"b"}`, patch: `{"c":null}`, want: `{"a":"b"}`},
+		{name: "array replaces array", doc: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "value replaces array", doc: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{name: "arrays are not merged", doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "array of objects replaces array", doc: `{"a":[1,2]}`, patch: `{"a":[{"b":"c"}]}`, want: `{"a":[{"b":"c"}]}`},
+		{
+			name:  "nested objects merge",
+			doc:   `{"a":{"b":"c","d":"e"},"f":"g"}`,
+			patch: `{"a":{"b":"x","d":null,"h":{"i":"j"}}}`,
+			want:  `{"a":{"b":"x","h":{"i":"j"}},"f":"g"}`,
+		},
+		{name: "object replaces value", doc: `{"a":"b"}`, patch: `{"a":{"c":"d","e":null}}`, want: `{"a":{"c":"d"}}`},
+		{name: "deep null", doc: `{"e":null}`, patch: `{"a":1}`, want: `{"e":null,"a":1}`},
+		{name: "patch array replaces document", doc: `{"a":"b"}`, patch: `["c"]`, want: `["c"]`},
+		{name: "patch null replaces document", doc: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{name: "patch string replaces document", doc: `{"a":"foo"}`, patch: `"bar"`, want: `"bar"`},
+		{name: "object patch of array document", doc: `[1,2]`, patch: `{"a":"b","c":null}`, want: `{"a":"b"}`},
+		{name: "empty patch", doc: `{"a":"b"}`, patch: `{}`, want: `{"a":"b"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var gotVal, wantVal interface{}
+			if err := json.Unmarshal(got, &gotVal); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantVal); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotVal, wantVal) {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergePatchRejectsMalformedPatch(t *testing.T) {
+	if _, err := MergePatch([]byte(`{"a":"b"}`), []byte(`{"a":`)); err != ErrBadRequest {
+		t.Fatalf("got %v, want ErrBadRequest", err)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	type location struct {
+		City    string `json:"city"`
+		Country string `json:"country"`
+	}
+	type profile struct {
+		Name     string    `json:"name"`
+		Tags     []string  `json:"tags"`
+		Location *location `json:"location"`
+	}
+	current := profile{Name: "alice", Tags: []string{"a", "b"}, Location: &location{City: "Moscow", Country: "Russia"}}
+
+	var patched profile
+	err := ApplyPatch(current, []byte(`{"name":null,"tags":["c"],"location":{"city":"Kazan"}}`), &patched)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := profile{Tags: []string{"c"}, Location: &location{City: "Kazan", Country: "Russia"}}
+	if !reflect.DeepEqual(patched, want) {
+		t.Fatalf("got %+v, want %+v", patched, want)
+	}
+	if current.Name != "alice" || current.Location.City != "Moscow" {
+		t.Fatalf("current value is changed: %+v", current)
+	}
+
+	var invalid profile
+	err = ApplyPatch(current, []byte(`{"tags":"c"}`), &invalid)
+	var appErr *AppError
+	if !errors.As(err, &appErr) || len(appErr.Details) != 1 || appErr.Details[0].Field != "tags" {
+		t.Fatalf("wrong type: got %v, want invalid tags field", err)
+	}
+	if err := ApplyPatch(current, []byte(`{"tags":`), &invalid); err != ErrBadRequest {
+		t.Fatalf("malformed patch: got %v, want ErrBadRequest", err)
+	}
+}
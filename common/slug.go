@@ -0,0 +1,25 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify normalizes name into slug, lower case letters and digits
+// separated by single hyphens, e.g. "Go & Web" becomes "go-web"
+func Slugify(name string) string {
+	var slug strings.Builder
+	separated := false
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			separated = true
+			continue
+		}
+		if separated && slug.Len() > 0 {
+			slug.WriteByte('-')
+		}
+		separated = false
+		slug.WriteRune(r)
+	}
+	return slug.String()
+}
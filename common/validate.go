@@ -0,0 +1,128 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/maxik12233/blog/types"
+)
+
+/* validate.go file stores validation of decoded requests. Rules are
+   declared with validate tags on request structs, invalid fields are
+   returned to the client in ErrorDetail list */
+
+var loginRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+const embeddedName = "~"
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	// Fields are named in errors the same way as in json
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return "-"
+		}
+		if name == "" && field.Anonymous {
+			// json inlines fields of embedded struct
+			return embeddedName
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	_ = v.RegisterValidation("login", func(fl validator.FieldLevel) bool {
+		return loginRegexp.MatchString(fl.Field().String())
+	})
+	return v
+}
+
+// Validate checks validate tags of req, it returns ErrBadRequest
+// with details if some fields are invalid
+func Validate(req interface{}) error {
+	err := validate.Struct(req)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return ErrBadRequest
+	}
+
+	details := make([]types.ErrorDetail, 0, len(fieldErrors))
+	for _, fe := range fieldErrors {
+		details = append(details, types.ErrorDetail{
+			ErrorType:    fe.Tag(),
+			ErrorMessage: fieldMessage(fe),
+			Field:        fieldName(fe),
+		})
+	}
+	return NewValidationError(details)
+}
+
+// InvalidField returns ErrBadRequest with details about field which
+// can't be parsed before validation
+func InvalidField(field string) error {
+	return NewValidationError([]types.ErrorDetail{{
+		ErrorType:    "invalid",
+		ErrorMessage: "is invalid",
+		Field:        field,
+	}})
+}
+
+// fieldName returns path of the field as it is named in json
+func fieldName(fe validator.FieldError) string {
+	parts := strings.Split(fe.Namespace(), ".")
+	names := make([]string, 0, len(parts))
+	// first part is name of the request struct
+	for _, part := range parts[1:] {
+		if part != embeddedName {
+			names = append(names, part)
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "login":
+		return "may contain only latin letters, digits, '_', '.' and '-'"
+	case "numeric":
+		return "must contain only digits"
+	case "len":
+		return fmt.Sprintf("must be exactly %s%s long", fe.Param(), unit)
+	case "min":
+		if unit == "" {
+			return fmt.Sprintf("must be at least %s", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s%s long", fe.Param(), unit)
+	case "max":
+		if unit == "" {
+			return fmt.Sprintf("must be at most %s", fe.Param())
+		}
+		return fmt.Sprintf("must be at most %s%s long", fe.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	}
+	return "is invalid"
+}
@@ -0,0 +1,144 @@
+package lockout
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/* lockout.go file stores protection from guessing passwords.
+   Every failed attempt of a key (login or client ip) is counted, after
+   free attempts the key is locked for exponentially growing time. Password
+   reset requests are limited the same way, every request is counted */
+
+// Attempt stores failed attempts of one key
+type Attempt struct {
+	Key         string `gorm:"primaryKey"`
+	Failures    int
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
+
+func (Attempt) TableName() string {
+	return "login_attempts"
+}
+
+// Store keeps attempts, it must be shared by all instances of the service
+type Store interface {
+	// Fail counts failed attempt of key, failures older than window are forgotten
+	Fail(key string, now time.Time, window time.Duration) (*Attempt, error)
+	Lock(key string, until time.Time) error
+	Get(key string) (*Attempt, error)
+	Reset(key string) error
+}
+
+// NewStore returns Postgres store if LOCKOUT_STORE is postgres, otherwise
+// attempts are kept in memory of this instance only
+func NewStore(db *gorm.DB, logger *zap.Logger) Store {
+	if os.Getenv("LOCKOUT_STORE") == "postgres" {
+		return NewPostgresStore(db, logger)
+	}
+	logger.Warn("LOCKOUT_STORE is not postgres, login attempts are not shared between instances")
+	return NewMemoryStore()
+}
+
+type Limiter struct {
+	store  Store
+	logger *zap.Logger
+}
+
+func NewLimiter(store Store, logger *zap.Logger) *Limiter {
+	return &Limiter{
+		store:  store,
+		logger: logger,
+	}
+}
+
+func LoginKey(login string) string {
+	return "login:" + login
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Keys of password reset requests are separate from keys of failed logins
+func ResetEmailKey(email string) string {
+	return "reset:email:" + strings.ToLower(email)
+}
+
+func ResetIPKey(ip string) string {
+	return "reset:ip:" + ip
+}
+
+// Check returns common.ErrTooManyAttempts with retry time if any of keys is locked
+func (l *Limiter) Check(keys ...string) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		attempt, err := l.store.Get(key)
+		if err != nil {
+			l.logger.Error("Error while getting login attempts", zap.Error(err))
+			return common.ErrInternalError
+		}
+		if attempt != nil && attempt.LockedUntil.After(now) && attempt.LockedUntil.Sub(now) > wait {
+			wait = attempt.LockedUntil.Sub(now)
+		}
+	}
+	if wait > 0 {
+		return common.NewRetryError(wait)
+	}
+	return nil
+}
+
+// Fail counts failed attempt of key which has free attempts without delay
+func (l *Limiter) Fail(key string, free int) error {
+	now := time.Now()
+	window := time.Hour * time.Duration(common.LOGIN_FAILURES_WINDOW_HOURS)
+	attempt, err := l.store.Fail(key, now, window)
+	if err != nil {
+		l.logger.Error("Error while counting failed login attempt", zap.Error(err))
+		return common.ErrInternalError
+	}
+
+	delay := backoff(attempt.Failures, free)
+	if delay == 0 {
+		return nil
+	}
+	l.logger.Warn("Too many failed login attempts, key is locked", zap.String("key", key), zap.Int("failures", attempt.Failures), zap.Duration("delay", delay))
+	if err := l.store.Lock(key, now.Add(delay)); err != nil {
+		l.logger.Error("Error while locking key", zap.Error(err))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (l *Limiter) Reset(key string) error {
+	if err := l.store.Reset(key); err != nil {
+		l.logger.Error("Error while resetting login attempts", zap.Error(err))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// backoff doubles delay with every failure after free ones,
+// starting from one second up to LOCKOUT_MAX_MINUTES
+func backoff(failures int, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	max := time.Minute * time.Duration(common.LOCKOUT_MAX_MINUTES)
+	n := failures - free - 1
+	if n >= 30 {
+		return max
+	}
+	delay := time.Second << n
+	if delay > max {
+		return max
+	}
+	return delay
+}
@@ -0,0 +1,141 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MemoryStore keeps attempts in memory, it is enough for a single instance
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]*Attempt
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string]*Attempt),
+	}
+}
+
+func (s *MemoryStore) Fail(key string, now time.Time, window time.Duration) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || now.Sub(attempt.UpdatedAt) > window {
+		attempt = &Attempt{Key: key}
+		s.attempts[key] = attempt
+	}
+	attempt.Failures++
+	attempt.UpdatedAt = now
+
+	// forget old attempts so map doesn't grow forever
+	if len(s.attempts) > 10000 {
+		for k, val := range s.attempts {
+			if now.Sub(val.UpdatedAt) > window && now.After(val.LockedUntil) {
+				delete(s.attempts, k)
+			}
+		}
+	}
+
+	res := *attempt
+	return &res, nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(key string) (*Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	res := *attempt
+	return &res, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// PostgresStore keeps attempts in login_attempts table shared by all instances
+type PostgresStore struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewPostgresStore(db *gorm.DB, logger *zap.Logger) *PostgresStore {
+	return &PostgresStore{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Fail increments failures in one statement so concurrent instances don't lose attempts
+func (s *PostgresStore) Fail(key string, now time.Time, window time.Duration) (*Attempt, error) {
+	attempt := Attempt{Key: key, Failures: 1, UpdatedAt: now}
+	result := s.db.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "failures"}, Value: gorm.Expr("CASE WHEN login_attempts.updated_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.Add(-window))},
+				{Column: clause.Column{Name: "updated_at"}, Value: now},
+			},
+		},
+		clause.Returning{},
+	).Create(&attempt)
+	if result.Error != nil {
+		s.logger.Error("Error while counting failed attempt", zap.Error(result.Error))
+		return nil, result.Error
+	}
+	return &attempt, nil
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	result := s.db.Model(&Attempt{}).Where("key = ? AND locked_until < ?", key, until).Update("locked_until", until)
+	if result.Error != nil {
+		s.logger.Error("Error while locking key", zap.Error(result.Error))
+		return result.Error
+	}
+	return nil
+}
+
+func (s *PostgresStore) Get(key string) (*Attempt, error) {
+	var attempt Attempt
+	result := s.db.Where("key = ?", key).Limit(1).Find(&attempt)
+	if result.Error != nil {
+		s.logger.Error("Error while getting attempts", zap.Error(result.Error))
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	result := s.db.Where("key = ?", key).Delete(&Attempt{})
+	if result.Error != nil {
+		s.logger.Error("Error while resetting attempts", zap.Error(result.Error))
+		return result.Error
+	}
+	return nil
+}
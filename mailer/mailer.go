@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+var ErrNoSMTP = errors.New("SMTP_ADDR is not set, set MAILER=memory to keep emails in memory in development")
+
+// NewMailer returns SMTP mailer set in SMTP_ADDR. Memory outbox, which doesn't
+// deliver emails, is used only when MAILER=memory is set explicitly
+func NewMailer(logger *zap.Logger) (Mailer, error) {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		if os.Getenv("MAILER") != "memory" {
+			return nil, ErrNoSMTP
+		}
+		logger.Warn("MAILER is memory, emails are not delivered")
+		return NewMemoryMailer(logger), nil
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "noreply@blog.local"
+	}
+	return NewSMTPMailer(addr, from, auth, logger), nil
+}
+
+type SMTPMailer struct {
+	addr   string
+	from   string
+	auth   smtp.Auth
+	logger *zap.Logger
+}
+
+func NewSMTPMailer(addr string, from string, auth smtp.Auth, logger *zap.Logger) Mailer {
+	return &SMTPMailer{
+		addr:   addr,
+		from:   from,
+		auth:   auth,
+		logger: logger,
+	}
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	m.logger.Info("In Send")
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", m.from, to, subject, body)
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+	if err != nil {
+		m.logger.Error("Error while sending email", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// maxOutbox is count of last messages kept by MemoryMailer
+const maxOutbox = 100
+
+// MemoryMailer keeps last sent messages in outbox, it is used in development and tests.
+// Message bodies contain tokens, so they are never logged
+type MemoryMailer struct {
+	mu     sync.Mutex
+	outbox []Message
+	logger *zap.Logger
+}
+
+func NewMemoryMailer(logger *zap.Logger) *MemoryMailer {
+	return &MemoryMailer{
+		logger: logger,
+	}
+}
+
+func (m *MemoryMailer) Send(to string, subject string, body string) error {
+	m.logger.Info("Email is kept in memory outbox", zap.String("to", to), zap.String("subject", subject))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.outbox) >= maxOutbox {
+		m.outbox = append(m.outbox[:0], m.outbox[len(m.outbox)-maxOutbox+1:]...)
+	}
+	m.outbox = append(m.outbox, Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Outbox returns copy of kept messages, the oldest first
+func (m *MemoryMailer) Outbox() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.outbox...)
+}
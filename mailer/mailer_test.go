@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMemoryMailerKeepsLastMessages(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	m := NewMemoryMailer(zap.New(core))
+
+	for i := 0; i < maxOutbox+5; i++ {
+		if err := m.Send("alice@example.com", "Reset", fmt.Sprintf("token %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbox := m.Outbox()
+	if len(outbox) != maxOutbox {
+		t.Fatalf("got %d messages, want %d", len(outbox), maxOutbox)
+	}
+	if outbox[0].Body != "token 5" || outbox[maxOutbox-1].Body != fmt.Sprintf("token %d", maxOutbox+4) {
+		t.Fatalf("wrong messages are kept: first %q, last %q", outbox[0].Body, outbox[maxOutbox-1].Body)
+	}
+	for _, entry := range logs.All() {
+		for key, val := range entry.ContextMap() {
+			if s, ok := val.(string); ok && strings.Contains(s, "token") {
+				t.Fatalf("body is logged in %q field %s", entry.Message, key)
+			}
+		}
+	}
+}
+
+func TestNewMailerNeedsSMTP(t *testing.T) {
+	t.Setenv("SMTP_ADDR", "")
+	t.Setenv("MAILER", "")
+	if _, err := NewMailer(zap.NewNop()); err != ErrNoSMTP {
+		t.Fatalf("got %v, want ErrNoSMTP", err)
+	}
+
+	t.Setenv("MAILER", "memory")
+	m, err := NewMailer(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*MemoryMailer); !ok {
+		t.Fatalf("got %T, want memory mailer", m)
+	}
+}
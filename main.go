@@ -5,29 +5,33 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	"github.com/maxik12233/blog/controllers"
-	"github.com/maxik12233/blog/services"
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/lockout"
+	"github.com/maxik12233/blog/mailer"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/oidc"
+	"github.com/maxik12233/blog/policy"
+	"github.com/maxik12233/blog/resource"
 	"github.com/maxik12233/blog/types"
 	"github.com/maxik12233/blog/user"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var (
-	router    *gin.Engine
 	muxrouter *mux.Router
 	db        *gorm.DB
 	logger    *zap.Logger
 	err       error
-
-	resservice    services.ResourceService
-	rescontroller controllers.ResourceController
 )
 
 func InitializeLogger() {
@@ -53,19 +57,178 @@ func initialMigration() {
 		os.Exit(1)
 	}
 
-	err = db.AutoMigrate(&user.User{}, &user.ContactInfo{}, &user.Location{}, &user.PersonalInfo{}, &types.Role{}, &types.Article{}, &types.Comment{}, &types.Like{})
+	err = db.AutoMigrate(&user.User{}, &user.ContactInfo{}, &user.Location{}, &user.PersonalInfo{}, &user.Session{}, &user.PasswordReset{}, &user.Verification{}, &user.TOTP{}, &user.RecoveryCode{}, &user.MFAChallenge{}, &user.Identity{}, &user.OIDCState{}, &user.APIToken{}, &types.RoleData{}, &types.RolePermission{}, &types.Role{}, &types.Tag{}, &types.Article{}, &types.ArticleRevision{}, &types.Comment{}, &types.Like{}, &types.AuditEntry{}, &types.Migration{}, &lockout.Attempt{})
 	if err != nil {
 		logger.Fatal("Failed automigration")
 		os.Exit(1)
 	}
 }
 
+// runOnce runs data migration in transaction unless it was applied before
+func runOnce(name string, migrate func(tx *gorm.DB) error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if result := tx.Model(&types.Migration{}).Where("name = ?", name).Count(&count); result.Error != nil {
+			return result.Error
+		}
+		if count > 0 {
+			return nil
+		}
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		logger.Info("Migration applied", zap.String("name", name))
+		return tx.Create(&types.Migration{Name: name, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		logger.Fatal("Failed to apply migration", zap.String("name", name), zap.Error(err))
+	}
+}
+
+// migrateBaselineRoles fixes roles of databases created before permissions.
+// Every user got role data 1 and 2 on registration, which were common and
+// moderator, so the moderator role is taken from users who have only this
+// pair. Role data was created on every start, so duplicates are merged into
+// the first role with the same name. Editing own articles and comments got
+// own permissions, so roles which create them are granted these permissions
+func migrateBaselineRoles(tx *gorm.DB) error {
+	var first []types.RoleData
+	if result := tx.Where("id IN ?", []uint{1, 2}).Order("id").Find(&first); result.Error != nil {
+		return result.Error
+	}
+	if len(first) == 2 && first[0].RoleName == types.RoleCommon && first[1].RoleName == types.RoleModerator {
+		result := tx.Exec(`DELETE FROM roles WHERE role_data_id = 2 AND user_id IN (
+			SELECT user_id FROM roles GROUP BY user_id
+			HAVING MIN(role_data_id) = 1 AND MAX(role_data_id) = 2)`)
+		if result.Error != nil {
+			return result.Error
+		}
+		logger.Info("Automatic moderator role removed", zap.Int64("users", result.RowsAffected))
+	}
+
+	var dups []types.RoleData
+	result := tx.Where("id NOT IN (?)", tx.Model(&types.RoleData{}).Select("MIN(id)").Group("role_name")).Find(&dups)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, dup := range dups {
+		var keep types.RoleData
+		if result := tx.Where("role_name = ?", dup.RoleName).Order("id").First(&keep); result.Error != nil {
+			return result.Error
+		}
+		statements := []string{
+			// users and permissions of duplicate are moved unless the kept role already has them
+			`DELETE FROM roles WHERE role_data_id = @dup AND user_id IN (SELECT user_id FROM roles WHERE role_data_id = @keep)`,
+			`UPDATE roles SET role_data_id = @keep WHERE role_data_id = @dup`,
+			`DELETE FROM role_permissions WHERE role_data_id = @dup AND permission IN (SELECT permission FROM role_permissions WHERE role_data_id = @keep)`,
+			`UPDATE role_permissions SET role_data_id = @keep WHERE role_data_id = @dup`,
+			`UPDATE role_data SET require_mfa = require_mfa OR (SELECT require_mfa FROM role_data WHERE id = @dup) WHERE id = @keep`,
+			`DELETE FROM role_data WHERE id = @dup`,
+		}
+		for _, statement := range statements {
+			if result := tx.Exec(statement, map[string]interface{}{"dup": dup.ID, "keep": keep.ID}); result.Error != nil {
+				return result.Error
+			}
+		}
+		logger.Info("Duplicate role merged", zap.String("role", dup.RoleName), zap.Uint("id", dup.ID), zap.Uint("into", keep.ID))
+	}
+
+	grants := map[types.Permission]types.Permission{
+		types.PermArticleCreate: types.PermArticleUpdateOwn,
+		types.PermCommentCreate: types.PermCommentUpdateOwn,
+	}
+	for create, update := range grants {
+		result := tx.Exec(`INSERT INTO role_permissions (role_data_id, permission)
+			SELECT role_data_id, @update FROM role_permissions WHERE permission = @create
+			AND role_data_id NOT IN (SELECT role_data_id FROM role_permissions WHERE permission = @update)`,
+			map[string]interface{}{"create": create, "update": update})
+		if result.Error != nil {
+			return result.Error
+		}
+		logger.Info("Role permission granted", zap.String("permission", string(update)), zap.Int64("roles", result.RowsAffected))
+	}
+
+	return nil
+}
+
+// fillRoleData creates default roles and adds missing default permissions to them
 func fillRoleData() {
-	db.Create([]types.RoleData{
-		{RoleName: "common"},
-		{RoleName: "moderator"},
-		{RoleName: "admin"},
+	for name, perms := range types.DefaultRolePermissions {
+		var roledata types.RoleData
+		result := db.Where(types.RoleData{RoleName: name}).Order("id").FirstOrCreate(&roledata)
+		if result.Error != nil {
+			logger.Fatal("Failed to create role", zap.String("role", name), zap.Error(result.Error))
+		}
+
+		for _, perm := range perms {
+			result = db.Where(types.RolePermission{RoleDataID: roledata.ID, Permission: perm}).FirstOrCreate(&types.RolePermission{})
+			if result.Error != nil {
+				logger.Fatal("Failed to add role permission", zap.String("role", name), zap.Error(result.Error))
+			}
+		}
+	}
+}
+
+// fillArticleRevisions gives the first revision to articles which were created before revisions
+func fillArticleRevisions() {
+	result := db.Exec(`INSERT INTO article_revisions (article_id, number, header, short_text, long_text, author_id, created_at)
+		SELECT id, 1, header, short_text, long_text, author_id, COALESCE(edited_at, NOW()) FROM articles
+		WHERE NOT EXISTS (SELECT 1 FROM article_revisions WHERE article_revisions.article_id = articles.id)`)
+	if result.Error != nil {
+		logger.Fatal("Failed to fill article revisions", zap.Error(result.Error))
+	}
+}
+
+// migrateTopics moves topics of articles, which were replaced by tags, into
+// tags and drops the topic column
+func migrateTopics() {
+	if !db.Migrator().HasColumn(&types.Article{}, "topic") {
+		return
+	}
+
+	var topics []struct {
+		ID    uint
+		Topic string
+	}
+	if result := db.Table("articles").Select("id, topic").Where("topic <> ''").Scan(&topics); result.Error != nil {
+		logger.Fatal("Failed to read article topics", zap.Error(result.Error))
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, art := range topics {
+			slug := common.Slugify(art.Topic)
+			if slug == "" {
+				continue
+			}
+			var tag types.Tag
+			result := tx.Where(types.Tag{Slug: slug}).Attrs(types.Tag{Name: strings.TrimSpace(art.Topic)}).FirstOrCreate(&tag)
+			if result.Error != nil {
+				return result.Error
+			}
+			result = tx.Exec("INSERT INTO article_tags (article_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING", art.ID, tag.ID)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return tx.Migrator().DropColumn(&types.Article{}, "topic")
 	})
+	if err != nil {
+		logger.Fatal("Failed to migrate article topics into tags", zap.Error(err))
+	}
+	logger.Info("Article topics migrated into tags", zap.Int("articles", len(topics)))
+}
+
+// envInt sets value from env variable name if it is set and within [min, max]
+func envInt(name string, value *int, min int, max int) {
+	val := os.Getenv(name)
+	if val == "" {
+		return
+	}
+	parsed, err := strconv.Atoi(val)
+	if err != nil || parsed < min || parsed > max {
+		logger.Fatal("Invalid value of env variable", zap.String("name", name), zap.String("value", val))
+	}
+	*value = parsed
 }
 
 func main() {
@@ -76,35 +239,90 @@ func main() {
 		logger.Fatal(err.Error())
 	}
 
+	if err := middleware.LoadKeys(); err != nil {
+		logger.Fatal("Unable to load jwt keys", zap.Error(err))
+	}
+	if os.Getenv("JWT_KEYS_DIR") == "" {
+		logger.Warn("JWT_KEYS_DIR is not set, using ephemeral signing key")
+	}
+
+	common.REQUIRE_VERIFIED_EMAIL = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+	common.TRUST_PROXY = os.Getenv("TRUST_PROXY") == "true"
+	if val := os.Getenv("PASSWORD_HASHER"); val != "" {
+		common.PASSWORD_HASHER = val
+	}
+	envInt("BCRYPT_COST", &common.BCRYPT_COST, bcrypt.MinCost, bcrypt.MaxCost)
+	envInt("ARGON2_MEMORY_KB", &common.ARGON2_MEMORY_KB, 8*1024, 4*1024*1024)
+	envInt("ARGON2_TIME", &common.ARGON2_TIME, 1, 100)
+	envInt("ARGON2_THREADS", &common.ARGON2_THREADS, 1, 255)
+
 	initialMigration()
 	defer func() {
 		dbInstance, _ := db.DB()
 		_ = dbInstance.Close()
 	}()
+	runOnce("baseline_roles", migrateBaselineRoles)
 	fillRoleData()
+	fillArticleRevisions()
+	migrateTopics()
 
-	router = gin.Default()
 	muxrouter = mux.NewRouter()
+	muxrouter.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		common.EncodeError(r.Context(), common.ErrNotFound, w)
+	})
+	muxrouter.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		common.EncodeError(r.Context(), common.ErrMethodNotAllowed, w)
+	})
+	muxrouter.Methods("GET").Path("/.well-known/jwks.json").HandlerFunc(middleware.JWKSHandler)
 
-	basepathGin := router.Group("/v1")
 	basepathMux := muxrouter.PathPrefix("/v1").Subrouter()
 
-	// res TODO rewrite to microservice
-	resservice = services.NewResourceService(db, logger.With(zap.String("service", "resource_service")))
-	rescontroller = controllers.NewResourceController(resservice)
-	rescontroller.RegisterResourceRoutes(basepathGin)
+	pol := policy.NewPolicy(db, logger.With(zap.String("service", "policy")))
+	mail, err := mailer.NewMailer(logger.With(zap.String("service", "mailer")))
+	if err != nil {
+		logger.Fatal("Unable to create mailer", zap.Error(err))
+	}
+	providers, err := oidc.LoadProviders()
+	if err != nil {
+		logger.Fatal("Unable to load oidc providers", zap.Error(err))
+	}
+	passwords, err := user.LoadPasswordPolicy(os.Getenv("BREACHED_PASSWORDS_FILE"))
+	if err != nil {
+		logger.Fatal("Unable to load breached passwords", zap.Error(err))
+	}
+	logger.Info("Password policy loaded", zap.Int("breached", passwords.BreachedCount()))
+	hasher, err := user.NewPasswordHasher(common.PASSWORD_HASHER, common.BCRYPT_COST, user.Argon2idHasher{
+		Memory:  uint32(common.ARGON2_MEMORY_KB),
+		Time:    uint32(common.ARGON2_TIME),
+		Threads: uint8(common.ARGON2_THREADS),
+		SaltLen: 16,
+		KeyLen:  32,
+	})
+	if err != nil {
+		logger.Fatal("Unable to create password hasher", zap.Error(err))
+	}
+	limiter := lockout.NewLimiter(lockout.NewStore(db, logger.With(zap.String("service", "lockout_store"))), logger.With(zap.String("service", "lockout")))
+
+	// resource microservice
+	resrepo := resource.NewResourceRepo(db, logger.With(zap.String("service", "resource_repository")))
+	ressvc := resource.NewResourceService(resrepo, pol, logger.With(zap.String("service", "resource_service")))
+	resEndpoints := resource.MakeResourceEndpoints(ressvc)
+	resource.CreateNewServer(basepathMux, resEndpoints)
+	go resource.RunPublishScheduler(ressvc, time.Duration(common.PUBLISH_INTERVAL_SEC)*time.Second)
 
 	// user microservice
 	repo := user.NewUserRepo(db, logger.With(zap.String("service", "user_repository")))
-	svc := user.NewUserService(repo, logger.With(zap.String("service", "user_service")))
+	middleware.SetRevocationStore(repo)
+	svc := user.NewUserService(repo, pol, mail, limiter, providers, passwords, hasher, logger.With(zap.String("service", "user_service")))
+	middleware.SetPermissionStore(svc)
+	middleware.SetAPITokenStore(svc)
 	userEndpoints := user.MakeUserEndpoints(svc)
 	user.CreateNewServer(basepathMux, userEndpoints)
 
 	var httpAddr = flag.String("http", os.Getenv("PORT"), "http lister address")
-	// Start the servers
-	go func() {
-		fmt.Println("listening on port: ", *httpAddr)
-		http.ListenAndServe(*httpAddr, muxrouter)
-	}()
-	router.Run(":6060")
+	// Start the server
+	fmt.Println("listening on port: ", *httpAddr)
+	if err := http.ListenAndServe(*httpAddr, middleware.RequestID(muxrouter)); err != nil {
+		logger.Fatal(err.Error())
+	}
 }
@@ -2,51 +2,122 @@ package middleware
 
 import (
 	"context"
-	"fmt"
+	"net"
 	"net/http"
-	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/types"
 )
 
+// RevocationStore tells if access token was revoked before its expiration
+type RevocationStore interface {
+	IsTokenRevoked(userid uint, version uint, sid string) (bool, error)
+}
+
+var revocationStore RevocationStore
+
+// SetRevocationStore sets store that is checked on every authorized request.
+// Without store tokens are valid until they expire
+func SetRevocationStore(store RevocationStore) {
+	revocationStore = store
+}
+
+// extractToken gets token from Authorization header or, if there is no
+// such header, from cookie. Header takes precedence, so malformed header
+// fails the request even if valid cookie is sent
+func extractToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return "", false
+		}
+		return strings.TrimSpace(token), true
+	}
+
+	cookie, err := r.Cookie(common.JWT_TOKEN_NAME)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// PermissionStore provides current permissions of the user, so changes
+// of user's roles take effect on the next request and not after new login
+type PermissionStore interface {
+	GetUserPermissions(userid uint) ([]types.Permission, error)
+}
+
+var permissionStore PermissionStore
+
+// SetPermissionStore sets store that is asked for permissions on every
+// authorized request. Without store permissions are taken from token
+func SetPermissionStore(store PermissionStore) {
+	permissionStore = store
+}
+
+// APITokenStore authenticates personal access tokens by their hash
+// and returns owner of the token and scopes it was created with
+type APITokenStore interface {
+	AuthenticateAPIToken(hash string) (uint, uint, []types.Permission, error)
+}
+
+var apiTokenStore APITokenStore
+
+// SetAPITokenStore enables personal access tokens, without store
+// only jwt tokens are accepted
+func SetAPITokenStore(store APITokenStore) {
+	apiTokenStore = store
+}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		var tokenString string
-		cookies := r.Cookies()
-		for _, val := range cookies {
-			if val.Name == common.JWT_TOKEN_NAME {
-				tokenString = val.Value
-			}
+		tokenString, ok := extractToken(r)
+		if !ok || tokenString == "0" || tokenString == "" {
+			common.EncodeError(r.Context(), common.ErrUnauthorized, w)
+			return
 		}
 
-		if tokenString == "0" || tokenString == "" {
-			common.ReturnAnauthorized(w)
+		if strings.HasPrefix(tokenString, common.API_TOKEN_PREFIX) {
+			ctx, err := authenticateAPIToken(r.Context(), tokenString)
+			if err != nil {
+				common.EncodeError(r.Context(), common.ErrUnauthorized, w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
 		// Decode/validate it
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
-
-			return []byte(os.Getenv("SECRET")), nil
-		})
+		token, err := jwt.Parse(tokenString, keySet.keyFunc)
 		if err != nil {
-			common.ReturnAnauthorized(w)
+			common.EncodeError(r.Context(), common.ErrUnauthorized, w)
 			return
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			// Check the exp
 			if float64(time.Now().Unix()) > claims["exp"].(float64) {
-				common.ReturnAnauthorized(w)
+				common.EncodeError(r.Context(), common.ErrUnauthorized, w)
 				return
 			}
 
+			// Check the token was not revoked
+			if revocationStore != nil {
+				sub, _ := claims["sub"].(float64)
+				ver, _ := claims["ver"].(float64)
+				sid, _ := claims["sid"].(string)
+				revoked, err := revocationStore.IsTokenRevoked(uint(sub), uint(ver), sid)
+				if err != nil || revoked {
+					common.EncodeError(r.Context(), common.ErrUnauthorized, w)
+					return
+				}
+			}
+
 			ctx := context.WithValue(
 				r.Context(),
 				"UserID",
@@ -57,48 +128,172 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 				"Roles",
 				claims["roles"],
 			)
+			ctx = context.WithValue(
+				ctx,
+				"SessionID",
+				claims["sid"],
+			)
+			perms, err := permissionsFromClaims(claims)
+			if err != nil {
+				common.EncodeError(r.Context(), common.ErrUnauthorized, w)
+				return
+			}
+			ctx = context.WithValue(
+				ctx,
+				"Permissions",
+				perms,
+			)
 
 			r = r.WithContext(ctx)
 
 			next.ServeHTTP(w, r)
 		} else {
-			common.ReturnAnauthorized(w)
+			common.EncodeError(r.Context(), common.ErrUnauthorized, w)
 		}
 	})
 }
 
-func ValidateRolesMiddleware(validRoles []uint) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// authenticateAPIToken puts owner of personal access token into context.
+// Token gets only those of its scopes which the owner still has
+func authenticateAPIToken(ctx context.Context, token string) (context.Context, error) {
+	if apiTokenStore == nil {
+		return nil, common.ErrUnauthorized
+	}
+	userid, tokenid, scopes, err := apiTokenStore.AuthenticateAPIToken(HashToken(token))
+	if err != nil {
+		return nil, err
+	}
 
-			rolesVal := r.Context().Value("Roles").([]interface{})
-			if rolesVal == nil {
-				common.ReturnAnauthorized(w)
-				return
+	perms := scopes
+	if permissionStore != nil {
+		current, err := permissionStore.GetUserPermissions(userid)
+		if err != nil {
+			return nil, err
+		}
+		perms = make([]types.Permission, 0, len(scopes))
+		for _, scope := range scopes {
+			if slices.Contains(current, scope) {
+				perms = append(perms, scope)
 			}
+		}
+	}
 
-			roles := make([]uint, len(rolesVal))
-			for i, val := range rolesVal {
-				roles[i] = uint(val.(float64))
-			}
-			if len(roles) == 0 {
-				common.ReturnAnauthorized(w)
-				return
-			}
+	// UserID is float64 like sub claim of jwt token
+	ctx = context.WithValue(ctx, "UserID", float64(userid))
+	ctx = context.WithValue(ctx, "APITokenID", tokenid)
+	ctx = context.WithValue(ctx, "Permissions", perms)
+	return ctx, nil
+}
 
-			validation := make(map[uint]uint)
-			for _, val := range roles {
-				validation[val] = 0
+// RequirePermissions lets request through only if token carries all of perms
+func RequirePermissions(perms ...types.Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, perm := range perms {
+				if !HasPermission(r.Context(), perm) {
+					common.EncodeError(r.Context(), common.ErrForbidden, w)
+					return
+				}
 			}
 
-			for _, val := range validRoles {
-				if _, ok := validation[val]; !ok {
-					common.ReturnAnauthorized(w)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAnyPermission lets request through if token carries at least one of perms
+func RequireAnyPermission(perms ...types.Permission) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, perm := range perms {
+				if HasPermission(r.Context(), perm) {
+					next.ServeHTTP(w, r)
 					return
 				}
 			}
 
-			next.ServeHTTP(w, r)
+			common.EncodeError(r.Context(), common.ErrForbidden, w)
 		})
 	}
 }
+
+// RejectAPITokens forbids request authorized by personal access token. It
+// guards credential and session management, so a leaked token of any scope
+// can't take over the account
+func RejectAPITokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetAPITokenID(r.Context()); ok {
+			common.EncodeError(r.Context(), common.ErrForbidden, w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func HasPermission(ctx context.Context, perm types.Permission) bool {
+	for _, val := range GetPermissions(ctx) {
+		if val == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func GetPermissions(ctx context.Context) []types.Permission {
+	perms, _ := ctx.Value("Permissions").([]types.Permission)
+	return perms
+}
+
+func permissionsFromClaims(claims jwt.MapClaims) ([]types.Permission, error) {
+	if permissionStore != nil {
+		sub, _ := claims["sub"].(float64)
+		return permissionStore.GetUserPermissions(uint(sub))
+	}
+
+	permsVal, _ := claims["perms"].([]interface{})
+	perms := make([]types.Permission, 0, len(permsVal))
+	for _, val := range permsVal {
+		if perm, ok := val.(string); ok {
+			perms = append(perms, types.Permission(perm))
+		}
+	}
+	return perms, nil
+}
+
+func GetUserID(ctx context.Context) (uint, bool) {
+	val, ok := ctx.Value("UserID").(float64)
+	if !ok {
+		return 0, false
+	}
+	return uint(val), true
+}
+
+// GetSessionID returns session family of the access token
+func GetSessionID(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value("SessionID").(string)
+	return val, ok && val != ""
+}
+
+// GetAPITokenID returns id of personal access token which authorized
+// the request, it is false for requests with jwt token
+func GetAPITokenID(ctx context.Context) (uint, bool) {
+	val, ok := ctx.Value("APITokenID").(uint)
+	return val, ok
+}
+
+// ClientIP returns address of the client, X-Forwarded-For is
+// used only behind trusted proxy because clients can forge it
+func ClientIP(r *http.Request) string {
+	if common.TRUST_PROXY {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -1,28 +1,54 @@
 package middleware
 
 import (
-	"os"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/types"
 )
 
-func GenerateJWT(ID uint, roles []uint) (string, error) {
+// GenerateJWT creates access token. version is the user's token version
+// and sid is the session family the token belongs to, both are checked
+// by LoggingMiddleware against RevocationStore
+func GenerateJWT(ID uint, roles []string, perms []types.Permission, version uint, sid string) (string, error) {
 
 	// Generate jwt
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"sub":   ID,
 		"roles": roles,
-		"exp":   time.Now().Add(time.Hour * time.Duration(common.JWT_TOKEN_EXP_HOURS)).Unix(),
-	})
+		"perms": perms,
+		"ver":   version,
+		"sid":   sid,
+		"exp":   time.Now().Add(time.Minute * time.Duration(common.JWT_TOKEN_EXP_MINUTES)).Unix(),
+	}
 
-	// Sign and get the complete encoded token as a string using the secret
-	tokenString, err := token.SignedString([]byte(os.Getenv("SECRET")))
+	// Sign and get the complete encoded token as a string using the current signing key
+	tokenString, err := keySet.sign(claims)
 
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// GenerateOpaqueToken returns random url-safe token which is
+// meaningless by itself and must be looked up by its hash
+func GenerateOpaqueToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns hash of opaque token suitable for storing in db
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/* keys.go file stores keys which are used to sign and verify jwt tokens.
+   Keys are read from JWT_KEYS_DIR where every <kid>.pem file holds one
+   private or public RSA or Ed25519 key. JWT_SIGNING_KID selects the key
+   which signs new tokens, all other keys are used only for verification.
+   Service which doesn't issue tokens needs only public keys */
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+type KeySet struct {
+	signingKid    string
+	signingMethod jwt.SigningMethod
+	signingKey    crypto.PrivateKey
+	keys          map[string]verificationKey
+}
+
+var keySet *KeySet
+
+var ErrNoSigningKey = errors.New("No signing key configured")
+
+// LoadKeys loads keys from directory set in JWT_KEYS_DIR. If directory is
+// not set, ephemeral key is generated, so tokens become invalid after restart
+func LoadKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		ks, err := generateEphemeralKeySet()
+		if err != nil {
+			return err
+		}
+		keySet = ks
+		return nil
+	}
+
+	ks, err := loadKeySet(dir, os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		return err
+	}
+	keySet = ks
+	return nil
+}
+
+func generateEphemeralKeySet() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := "ephemeral"
+	return &KeySet{
+		signingKid:    kid,
+		signingMethod: jwt.SigningMethodEdDSA,
+		signingKey:    priv,
+		keys: map[string]verificationKey{
+			kid: {method: jwt.SigningMethodEdDSA, key: pub},
+		},
+	}, nil
+}
+
+func loadKeySet(dir string, signingKid string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{
+		keys: make(map[string]verificationKey),
+	}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		method, err := methodForKey(pub)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		ks.keys[kid] = verificationKey{method: method, key: pub}
+
+		if kid == signingKid {
+			if priv == nil {
+				return nil, fmt.Errorf("key %s: signing key must be private", kid)
+			}
+			ks.signingKid = kid
+			ks.signingMethod = method
+			ks.signingKey = priv
+		}
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+	if signingKid != "" && ks.signingKey == nil {
+		return nil, fmt.Errorf("signing key %s not found in %s", signingKid, dir)
+	}
+	return ks, nil
+}
+
+// parseKey parses PEM encoded private or public key. For private key
+// its public part is returned too
+func parseKey(data []byte) (crypto.PrivateKey, crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("invalid pem")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, &k.PublicKey, nil
+		case ed25519.PrivateKey:
+			return k, k.Public(), nil
+		}
+		return nil, nil, errors.New("unsupported private key type")
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, &key.PublicKey, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, key, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported pem block %s", block.Type)
+}
+
+func methodForKey(pub crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, errors.New("unsupported public key type")
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks == nil || ks.signingKey == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(ks.signingMethod, claims)
+	token.Header["kid"] = ks.signingKid
+	return token.SignedString(ks.signingKey)
+}
+
+// keyFunc finds verification key by kid header and makes sure
+// token is signed with algorithm expected for that key
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if ks == nil {
+		return nil, errors.New("No verification keys configured")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id: %v", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.key, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (ks *KeySet) jwks() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if ks == nil {
+		return set
+	}
+
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch k := key.key.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler serves public verification keys for /.well-known/jwks.json
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(keySet.jwks())
+}
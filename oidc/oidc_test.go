@@ -0,0 +1,212 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testClientID = "blog-client"
+
+// stubIssuer is OpenID provider with discovery document, JWKS and
+// token endpoint which returns preset id token
+type stubIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+
+	mu      sync.Mutex
+	idToken string
+	form    url.Values
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(metadata{
+			Issuer:                s.URL,
+			AuthorizationEndpoint: s.URL + "/authorize",
+			TokenEndpoint:         s.URL + "/token",
+			JWKSURI:               s.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]jwk{"keys": {{
+			Kty: "RSA",
+			Kid: s.kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.form = r.PostForm
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stubIssuer) provider() *Provider {
+	return NewProvider("stub", s.URL, testClientID, "", "http://localhost/callback")
+}
+
+// claims returns valid claims of id token for nonce
+func (s *stubIssuer) claims(nonce string) *Claims {
+	now := time.Now()
+	return &Claims{
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Nonce:         nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.URL,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func (s *stubIssuer) sign(t *testing.T, claims *Claims, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newStubIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+		kid    string
+		key    *rsa.PrivateKey
+		valid  bool
+	}{
+		{name: "valid token", valid: true},
+		{name: "wrong audience", modify: func(c *Claims) { c.Audience = jwt.ClaimStrings{"other-client"} }},
+		{name: "wrong issuer", modify: func(c *Claims) { c.Issuer = "https://evil.example.com" }},
+		{name: "nonce mismatch", modify: func(c *Claims) { c.Nonce = "other-nonce" }},
+		{name: "expired", modify: func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }},
+		{name: "no expiration", modify: func(c *Claims) { c.ExpiresAt = nil }},
+		{name: "no subject", modify: func(c *Claims) { c.Subject = "" }},
+		{name: "unknown kid", kid: "key-2", key: otherKey},
+		{name: "known kid with wrong key", key: otherKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := issuer.claims("nonce-1")
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			kid, key := issuer.kid, issuer.key
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+			if tt.key != nil {
+				key = tt.key
+			}
+
+			got, err := issuer.provider().verify(issuer.sign(t, claims, kid, key), "nonce-1")
+			if tt.valid {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.Subject != "subject-1" || got.Email != "alice@example.com" {
+					t.Fatalf("unexpected claims %+v", got)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("got %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsUnsignedToken(t *testing.T) {
+	issuer := newStubIssuer(t)
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, issuer.claims("nonce-1"))
+	raw, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.provider().verify(raw, "nonce-1"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newStubIssuer(t)
+	issuer.idToken = issuer.sign(t, issuer.claims("nonce-1"), issuer.kid, issuer.key)
+	p := issuer.provider()
+
+	claims, err := p.Exchange("code-1", "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if claims.Subject != "subject-1" {
+		t.Fatalf("unexpected subject %s", claims.Subject)
+	}
+
+	want := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          "code-1",
+		"code_verifier": "verifier-1",
+		"redirect_uri":  "http://localhost/callback",
+		"client_id":     testClientID,
+	}
+	for key, val := range want {
+		if got := issuer.form.Get(key); got != val {
+			t.Errorf("token request %s = %q, want %q", key, got, val)
+		}
+	}
+
+	if _, err := p.Exchange("code-1", "verifier-1", "other-nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("nonce mismatch: got %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestMetadataRejectsIssuerMismatch(t *testing.T) {
+	issuer := newStubIssuer(t)
+	p := NewProvider("stub", issuer.URL+"/other", testClientID, "", "http://localhost/callback")
+	if _, err := p.AuthCodeURL("state", "nonce", "challenge"); err == nil {
+		t.Fatal("metadata of other issuer was accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"net/http"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/types"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+/* policy.go file stores ownership rules for mutating resources.
+   Owner can always change own resource, anybody else needs permission
+   for any resource and must send OVERRIDE_HEADER with the reason,
+   which is written to the audit log */
+
+const OVERRIDE_HEADER = "X-Override-Reason"
+
+// Actor is the authorized user who performs the request
+type Actor struct {
+	UserID         uint
+	Permissions    []types.Permission
+	OverrideReason string
+}
+
+func (a Actor) Can(perm types.Permission) bool {
+	for _, val := range a.Permissions {
+		if val == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// ActorFromRequest reads actor from request which passed LoggingMiddleware
+func ActorFromRequest(r *http.Request) (Actor, error) {
+	userid, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		return Actor{}, common.ErrUnauthorized
+	}
+	return Actor{
+		UserID:         userid,
+		Permissions:    middleware.GetPermissions(r.Context()),
+		OverrideReason: r.Header.Get(OVERRIDE_HEADER),
+	}, nil
+}
+
+type Policy interface {
+	// Enforce allows action on resource of ownerid. ownPerm is required from
+	// the owner, empty ownPerm means owner needs no permission. anyPerm lets
+	// non-owner perform the action with explicit override
+	Enforce(actor Actor, ownerid uint, ownPerm types.Permission, anyPerm types.Permission, action string, targetid uint) error
+}
+
+type PolicyImpl struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewPolicy(db *gorm.DB, logger *zap.Logger) Policy {
+	return &PolicyImpl{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (p *PolicyImpl) Enforce(actor Actor, ownerid uint, ownPerm types.Permission, anyPerm types.Permission, action string, targetid uint) error {
+	if actor.UserID == ownerid && (ownPerm == "" || actor.Can(ownPerm)) {
+		return nil
+	}
+
+	if !actor.Can(anyPerm) {
+		p.logger.Info("Action on not owned resource is forbidden", zap.String("action", action), zap.Uint("actorid", actor.UserID), zap.Uint("targetid", targetid))
+		return common.ErrForbidden
+	}
+	if actor.OverrideReason == "" {
+		p.logger.Info("Override reason is required", zap.String("action", action), zap.Uint("actorid", actor.UserID), zap.Uint("targetid", targetid))
+		return common.ErrForbidden
+	}
+
+	entry := types.AuditEntry{
+		ActorID:  actor.UserID,
+		OwnerID:  ownerid,
+		Action:   action,
+		TargetID: targetid,
+		Reason:   actor.OverrideReason,
+	}
+	if result := p.db.Create(&entry); result.Error != nil {
+		p.logger.Error("Error while writing audit entry", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	p.logger.Warn("Override used", zap.String("action", action), zap.Uint("actorid", actor.UserID), zap.Uint("ownerid", ownerid), zap.Uint("targetid", targetid), zap.String("reason", actor.OverrideReason))
+
+	return nil
+}
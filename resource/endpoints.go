@@ -0,0 +1,271 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/maxik12233/blog/common"
+)
+
+type ResourceEndpoints struct {
+	CreateArticle       endpoint.Endpoint
+	DeleteArticle       endpoint.Endpoint
+	UpdateArticle       endpoint.Endpoint
+	GetOneArticle       endpoint.Endpoint
+	GetArticles         endpoint.Endpoint
+	ChangeArticleStatus endpoint.Endpoint
+	GetRevisions        endpoint.Endpoint
+	GetRevision         endpoint.Endpoint
+	DiffRevisions       endpoint.Endpoint
+	RestoreRevision     endpoint.Endpoint
+	CreateComment       endpoint.Endpoint
+	DeleteComment       endpoint.Endpoint
+	UpdateComment       endpoint.Endpoint
+	GetArticleComments  endpoint.Endpoint
+	ToggleLike          endpoint.Endpoint
+	GetTagCloud         endpoint.Endpoint
+	CreateTag           endpoint.Endpoint
+	UpdateTag           endpoint.Endpoint
+	DeleteTag           endpoint.Endpoint
+}
+
+func MakeResourceEndpoints(s ResourceService) ResourceEndpoints {
+	return ResourceEndpoints{
+		CreateArticle:       makeCreateArticleEndpoint(s),
+		DeleteArticle:       makeDeleteArticleEndpoint(s),
+		UpdateArticle:       makeUpdateArticleEndpoint(s),
+		GetOneArticle:       makeGetOneArticleEndpoint(s),
+		GetArticles:         makeGetArticlesEndpoint(s),
+		ChangeArticleStatus: makeChangeArticleStatusEndpoint(s),
+		GetRevisions:        makeGetRevisionsEndpoint(s),
+		GetRevision:         makeGetRevisionEndpoint(s),
+		DiffRevisions:       makeDiffRevisionsEndpoint(s),
+		RestoreRevision:     makeRestoreRevisionEndpoint(s),
+		CreateComment:       makeCreateCommentEndpoint(s),
+		DeleteComment:       makeDeleteCommentEndpoint(s),
+		UpdateComment:       makeUpdateCommentEndpoint(s),
+		GetArticleComments:  makeGetArticleCommentsEndpoint(s),
+		ToggleLike:          makeToggleLikeEndpoint(s),
+		GetTagCloud:         makeGetTagCloudEndpoint(s),
+		CreateTag:           makeCreateTagEndpoint(s),
+		UpdateTag:           makeUpdateTagEndpoint(s),
+		DeleteTag:           makeDeleteTagEndpoint(s),
+	}
+}
+
+func makeCreateArticleEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateArticleRequest)
+		id, err := s.CreateArticle(&req.Article)
+		if err != nil {
+			return nil, err
+		}
+		return CreateArticleResponse{ID: id, Message: "Article created"}, nil
+	}
+}
+
+func makeDeleteArticleEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteArticleRequest)
+		err := s.DeleteArticle(&req)
+		if err != nil {
+			return nil, err
+		}
+		return "Article deleted", nil
+	}
+}
+
+func makeUpdateArticleEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateArticleRequest)
+		art, err := s.UpdateArticle(&req)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticleResponse{Article: art}, nil
+	}
+}
+
+func makeGetOneArticleEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetArticleRequest)
+		art, err := s.GetOneArticle(req.ID, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticleResponse{Article: art}, nil
+	}
+}
+
+func makeGetArticlesEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetArticlesRequest)
+		arts, count, err := s.GetArticles(&req)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticlesResponse{
+			Articles:   arts,
+			TotalCount: count,
+		}, nil
+	}
+}
+
+func makeChangeArticleStatusEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChangeArticleStatusRequest)
+		art, err := s.ChangeArticleStatus(&req)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticleResponse{Article: art}, nil
+	}
+}
+
+func makeGetRevisionsEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetRevisionsRequest)
+		revs, err := s.GetRevisions(req.ArticleID, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return GetRevisionsResponse{Revisions: revs}, nil
+	}
+}
+
+func makeGetRevisionEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetRevisionRequest)
+		rev, err := s.GetRevision(req.ArticleID, req.Number, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return GetRevisionResponse{Revision: rev}, nil
+	}
+}
+
+func makeDiffRevisionsEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DiffRevisionsRequest)
+		from, to, err := s.DiffRevisions(req.ArticleID, req.From, req.To, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return DiffRevisionsResponse{
+			From:      from.Number,
+			To:        to.Number,
+			Header:    common.DiffLines(from.Header, to.Header),
+			ShortText: common.DiffLines(from.ShortText, to.ShortText),
+			LongText:  common.DiffLines(from.LongText, to.LongText),
+		}, nil
+	}
+}
+
+func makeRestoreRevisionEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RestoreRevisionRequest)
+		art, err := s.RestoreRevision(&req)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticleResponse{Article: art}, nil
+	}
+}
+
+func makeCreateCommentEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateCommentRequest)
+		id, err := s.CreateComment(&req.Comment)
+		if err != nil {
+			return nil, err
+		}
+		return CreateCommentResponse{ID: id, Message: "Comment created"}, nil
+	}
+}
+
+func makeDeleteCommentEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteCommentRequest)
+		err := s.DeleteComment(&req)
+		if err != nil {
+			return nil, err
+		}
+		return "Comment deleted", nil
+	}
+}
+
+func makeUpdateCommentEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateCommentRequest)
+		comm, err := s.UpdateComment(&req)
+		if err != nil {
+			return nil, err
+		}
+		return UpdateCommentResponse{Comment: comm}, nil
+	}
+}
+
+func makeGetArticleCommentsEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetArticleCommentsRequest)
+		comms, err := s.GetArticleComments(req.ArticleID, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return GetArticleCommentsResponse{Comments: comms}, nil
+	}
+}
+
+func makeToggleLikeEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ToggleLikeRequest)
+		err := s.ToggleLike(&req.Like, req.Flag, req.Actor)
+		if err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	}
+}
+
+func makeGetTagCloudEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		tags, err := s.GetTagCloud()
+		if err != nil {
+			return nil, err
+		}
+		return GetTagCloudResponse{Tags: tags}, nil
+	}
+}
+
+func makeCreateTagEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateTagRequest)
+		id, err := s.CreateTag(&req.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return CreateTagResponse{ID: id, Message: "Tag created"}, nil
+	}
+}
+
+func makeUpdateTagEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UpdateTagRequest)
+		tag, err := s.UpdateTag(&req)
+		if err != nil {
+			return nil, err
+		}
+		return UpdateTagResponse{Tag: tag}, nil
+	}
+}
+
+func makeDeleteTagEndpoint(s ResourceService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeleteTagRequest)
+		err := s.DeleteTag(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return "Tag deleted", nil
+	}
+}
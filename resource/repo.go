@@ -0,0 +1,507 @@
+package resource
+
+import (
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/types"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ResourceRepository interface {
+	CreateArticle(art *types.Article) (uint, error)
+	DeleteArticle(id uint) error
+	GetArticles(req *GetArticlesRequest, authorid uint) ([]*types.Article, int, error)
+	GetOneArticle(id uint) (*types.Article, error)
+	UpdateArticle(art *types.Article, rev *types.ArticleRevision) error
+	ChangeArticleStatus(art *types.Article, from types.ArticleStatus) error
+	PublishScheduled(now time.Time) (int64, error)
+
+	GetRevisions(artid uint) ([]*types.ArticleRevision, error)
+	GetRevision(artid uint, number uint) (*types.ArticleRevision, error)
+
+	CreateComment(comm *types.Comment) (uint, error)
+	DeleteComment(id uint) error
+	GetOneComment(id uint) (*types.Comment, error)
+	UpdateComment(comm *types.Comment) error
+	GetArticleComments(artid uint) ([]*types.Comment, error)
+
+	CountLikes(like *types.Like) (int64, error)
+	CreateLike(like *types.Like) error
+	DeleteLike(like *types.Like) error
+
+	GetTagCloud() ([]*types.TagCount, error)
+	GetTagsBySlugs(slugs []string) ([]types.Tag, error)
+	GetTag(id uint) (*types.Tag, error)
+	CountTags(slug string, exceptid uint) (int64, error)
+	CreateTag(tag *types.Tag) (uint, error)
+	UpdateTag(tag *types.Tag) error
+	DeleteTag(id uint) error
+}
+
+type ResourceRepo struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+func NewResourceRepo(db *gorm.DB, logger *zap.Logger) ResourceRepository {
+	return &ResourceRepo{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// CreateArticle creates article together with its first revision
+func (repo *ResourceRepo) CreateArticle(art *types.Article) (uint, error) {
+	repo.logger.Info("In CreateArticle")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if result := tx.Create(art); result.Error != nil {
+			return result.Error
+		}
+		rev := types.ArticleRevision{
+			ArticleID: art.ID,
+			Number:    1,
+			Header:    art.Header,
+			ShortText: art.ShortText,
+			LongText:  art.LongText,
+			AuthorID:  art.AuthorID,
+		}
+		return tx.Create(&rev).Error
+	})
+	if err != nil {
+		repo.logger.Error("Error while creating article", zap.Error(err))
+		return 0, common.ErrInternalError
+	}
+	return art.ID, nil
+}
+
+func (repo *ResourceRepo) DeleteArticle(id uint) error {
+	repo.logger.Info("In DeleteArticle")
+
+	var art *types.Article
+	result := repo.db.Find(&art, id)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting article from db", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Article not found by id while deleting article")
+		return common.ErrNotFound
+	}
+
+	result = repo.db.Unscoped().Delete(&art)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting article from db", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+func (repo *ResourceRepo) GetOneArticle(id uint) (*types.Article, error) {
+	repo.logger.Info("In GetOneArticle")
+
+	var art *types.Article
+	result := repo.db.Where("ID = ?", id).Preload(clause.Associations).Find(&art)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching article by id from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Article not found by id")
+		return nil, common.ErrNotFound
+	}
+
+	return art, nil
+}
+
+// UpdateArticle saves content and tags of the article and its new revision
+// if article's version wasn't changed since art was read, otherwise
+// ErrPreconditionFailed is returned. Number of rev is set here, nil rev
+// means texts weren't changed
+func (repo *ResourceRepo) UpdateArticle(art *types.Article, rev *types.ArticleRevision) error {
+	repo.logger.Info("In UpdateArticle")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&types.Article{}).Where("id = ? AND version = ?", art.ID, art.Version).Updates(map[string]interface{}{
+			"header":     art.Header,
+			"short_text": art.ShortText,
+			"long_text":  art.LongText,
+			"edited_at":  art.EditedAt,
+			"version":    gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.ErrPreconditionFailed
+		}
+
+		if err := tx.Exec("DELETE FROM article_tags WHERE article_id = ?", art.ID).Error; err != nil {
+			return err
+		}
+		if len(art.Tags) > 0 {
+			links := make([]map[string]interface{}, 0, len(art.Tags))
+			for _, tag := range art.Tags {
+				links = append(links, map[string]interface{}{"article_id": art.ID, "tag_id": tag.ID})
+			}
+			if err := tx.Table("article_tags").Create(links).Error; err != nil {
+				return err
+			}
+		}
+		if rev == nil {
+			return nil
+		}
+
+		// Updated article row stays locked until commit, so numbers don't collide
+		var last uint
+		result = tx.Model(&types.ArticleRevision{}).Where("article_id = ?", art.ID).Select("COALESCE(MAX(number), 0)").Scan(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+		rev.ArticleID = art.ID
+		rev.Number = last + 1
+		return tx.Create(rev).Error
+	})
+	if err == common.ErrPreconditionFailed {
+		repo.logger.Info("Article was changed or deleted while updating article")
+		return err
+	}
+	if err != nil {
+		repo.logger.Error("Error while updating article", zap.Error(err))
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+// GetArticles returns page of articles with requested status and tags,
+// zero authorid means any author
+func (repo *ResourceRepo) GetArticles(req *GetArticlesRequest, authorid uint) ([]*types.Article, int, error) {
+	repo.logger.Info("In GetArticles")
+
+	query := repo.db.Model(&types.Article{}).Where("status = ?", req.Status)
+	if authorid != 0 {
+		query = query.Where("author_id = ?", authorid)
+	}
+	if len(req.Tags) > 0 {
+		tagged := repo.db.Table("article_tags").Select("article_tags.article_id").
+			Joins("JOIN tags ON tags.id = article_tags.tag_id").Where("tags.slug IN ?", req.Tags)
+		if req.Match == "all" {
+			tagged = tagged.Group("article_tags.article_id").Having("COUNT(DISTINCT tags.id) = ?", len(req.Tags))
+		}
+		query = query.Where("id IN (?)", tagged)
+	}
+
+	var count int64
+	if result := query.Session(&gorm.Session{}).Count(&count); result.Error != nil {
+		repo.logger.Error("Error while counting articles", zap.Error(result.Error))
+		return nil, 0, common.ErrInternalError
+	}
+
+	var arts []*types.Article
+	result := query.Preload(clause.Associations).Order("id").Limit(int(req.Amount)).Offset(int(req.Amount * req.Page)).Find(&arts)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching articles from db", zap.Error(result.Error))
+		return nil, 0, common.ErrInternalError
+	}
+
+	return arts, int(count), nil
+}
+
+// ChangeArticleStatus saves status and publication times of the article if
+// it wasn't changed since art with status from was read
+func (repo *ResourceRepo) ChangeArticleStatus(art *types.Article, from types.ArticleStatus) error {
+	repo.logger.Info("In ChangeArticleStatus")
+
+	result := repo.db.Model(&types.Article{}).Where("id = ? AND version = ? AND status = ?", art.ID, art.Version, from).Updates(map[string]interface{}{
+		"status":       art.Status,
+		"publish_at":   art.PublishAt,
+		"published_at": art.PublishedAt,
+		"version":      gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		repo.logger.Error("Error while changing article status", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Article was changed or deleted while changing its status")
+		return common.ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// PublishScheduled publishes all articles scheduled before now and returns their count
+func (repo *ResourceRepo) PublishScheduled(now time.Time) (int64, error) {
+	result := repo.db.Model(&types.Article{}).Where("status <> ? AND publish_at <= ?", types.ArticlePublished, now).Updates(map[string]interface{}{
+		"status":       types.ArticlePublished,
+		"published_at": gorm.Expr("publish_at"),
+		"publish_at":   nil,
+		"version":      gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		repo.logger.Error("Error while publishing scheduled articles", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+
+	return result.RowsAffected, nil
+}
+
+// GetRevisions returns revisions of the article without their texts
+func (repo *ResourceRepo) GetRevisions(artid uint) ([]*types.ArticleRevision, error) {
+	repo.logger.Info("In GetRevisions")
+
+	var revs []*types.ArticleRevision
+	result := repo.db.Omit("short_text", "long_text").Where("article_id = ?", artid).Order("number").Find(&revs)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching article revisions from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return revs, nil
+}
+
+func (repo *ResourceRepo) GetRevision(artid uint, number uint) (*types.ArticleRevision, error) {
+	repo.logger.Info("In GetRevision")
+
+	var rev *types.ArticleRevision
+	result := repo.db.Where("article_id = ? AND number = ?", artid, number).Find(&rev)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching article revision from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Article revision not found")
+		return nil, common.ErrNotFound
+	}
+
+	return rev, nil
+}
+
+func (repo *ResourceRepo) CreateComment(comm *types.Comment) (uint, error) {
+	repo.logger.Info("In CreateComment")
+
+	if result := repo.db.Create(comm); result.Error != nil {
+		repo.logger.Error("Error while creating comment", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+	return comm.ID, nil
+}
+
+func (repo *ResourceRepo) DeleteComment(id uint) error {
+	repo.logger.Info("In DeleteComment")
+
+	var comm *types.Comment
+	result := repo.db.Find(&comm, id)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting comment from db", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Comment not found by id while deleting comment")
+		return common.ErrNotFound
+	}
+
+	result = repo.db.Unscoped().Delete(&comm)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting comment from db", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+func (repo *ResourceRepo) GetOneComment(id uint) (*types.Comment, error) {
+	repo.logger.Info("In GetOneComment")
+
+	var comm *types.Comment
+	result := repo.db.Where("ID = ?", id).Find(&comm)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching comment by id from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Comment not found by id")
+		return nil, common.ErrNotFound
+	}
+
+	return comm, nil
+}
+
+// UpdateComment saves text of the comment if its version wasn't
+// changed since comm was read, otherwise ErrPreconditionFailed is returned
+func (repo *ResourceRepo) UpdateComment(comm *types.Comment) error {
+	repo.logger.Info("In UpdateComment")
+
+	result := repo.db.Model(&types.Comment{}).Where("id = ? AND version = ?", comm.ID, comm.Version).Updates(map[string]interface{}{
+		"raw_text":  comm.RawText,
+		"edited_at": comm.EditedAt,
+		"version":   gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		repo.logger.Error("Error while updating comment", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Comment was changed or deleted while updating comment")
+		return common.ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func (repo *ResourceRepo) GetArticleComments(artid uint) ([]*types.Comment, error) {
+	repo.logger.Info("In GetArticleComments")
+
+	var comms []*types.Comment
+	result := repo.db.Where("article_id = ?", artid).Preload("Like").Find(&comms)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching article comments from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return comms, nil
+}
+
+func (repo *ResourceRepo) CountLikes(like *types.Like) (int64, error) {
+	repo.logger.Info("In CountLikes")
+
+	var count int64
+	result := repo.db.Model(&types.Like{}).Where("user_id = ? AND (article_id = ? OR comment_id = ?)", like.UserID, like.ArticleID, like.CommentID).Count(&count)
+	if result.Error != nil {
+		repo.logger.Error("Error while counting likes", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+
+	return count, nil
+}
+
+func (repo *ResourceRepo) CreateLike(like *types.Like) error {
+	repo.logger.Info("In CreateLike")
+
+	if result := repo.db.Create(like); result.Error != nil {
+		repo.logger.Error("Error while creating like", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *ResourceRepo) DeleteLike(like *types.Like) error {
+	repo.logger.Info("In DeleteLike")
+
+	result := repo.db.Delete(&types.Like{}, "user_id = ? AND (article_id = ? OR article_id IS NULL) AND (comment_id = ? OR comment_id IS NULL)", like.UserID, like.ArticleID, like.CommentID)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting like", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// GetTagCloud returns all tags with counts of published articles, most used first
+func (repo *ResourceRepo) GetTagCloud() ([]*types.TagCount, error) {
+	repo.logger.Info("In GetTagCloud")
+
+	var tags []*types.TagCount
+	result := repo.db.Table("tags").Select("tags.id, tags.slug, tags.name, COUNT(articles.id) AS count").
+		Joins("LEFT JOIN article_tags ON article_tags.tag_id = tags.id").
+		Joins("LEFT JOIN articles ON articles.id = article_tags.article_id AND articles.status = ?", types.ArticlePublished).
+		Group("tags.id").Order("count DESC, tags.slug").Scan(&tags)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching tag cloud from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return tags, nil
+}
+
+func (repo *ResourceRepo) GetTagsBySlugs(slugs []string) ([]types.Tag, error) {
+	repo.logger.Info("In GetTagsBySlugs")
+
+	var tags []types.Tag
+	if result := repo.db.Where("slug IN ?", slugs).Find(&tags); result.Error != nil {
+		repo.logger.Error("Error while fetching tags by slugs from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return tags, nil
+}
+
+func (repo *ResourceRepo) GetTag(id uint) (*types.Tag, error) {
+	repo.logger.Info("In GetTag")
+
+	var tag *types.Tag
+	result := repo.db.Where("id = ?", id).Find(&tag)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching tag by id from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Tag not found by id")
+		return nil, common.ErrNotFound
+	}
+
+	return tag, nil
+}
+
+// CountTags counts tags with the slug except tag with exceptid
+func (repo *ResourceRepo) CountTags(slug string, exceptid uint) (int64, error) {
+	repo.logger.Info("In CountTags")
+
+	var count int64
+	if result := repo.db.Model(&types.Tag{}).Where("slug = ? AND id <> ?", slug, exceptid).Count(&count); result.Error != nil {
+		repo.logger.Error("Error while counting tags", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+
+	return count, nil
+}
+
+func (repo *ResourceRepo) CreateTag(tag *types.Tag) (uint, error) {
+	repo.logger.Info("In CreateTag")
+
+	if result := repo.db.Create(tag); result.Error != nil {
+		repo.logger.Error("Error while creating tag", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+	return tag.ID, nil
+}
+
+func (repo *ResourceRepo) UpdateTag(tag *types.Tag) error {
+	repo.logger.Info("In UpdateTag")
+
+	result := repo.db.Model(&types.Tag{}).Where("id = ?", tag.ID).Updates(map[string]interface{}{
+		"slug": tag.Slug,
+		"name": tag.Name,
+	})
+	if result.Error != nil {
+		repo.logger.Error("Error while updating tag", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Tag not found by id while updating tag")
+		return common.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteTag deletes tag, it is removed from all articles
+func (repo *ResourceRepo) DeleteTag(id uint) error {
+	repo.logger.Info("In DeleteTag")
+
+	result := repo.db.Delete(&types.Tag{}, id)
+	if result.Error != nil {
+		repo.logger.Error("Error while deleting tag from db", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Tag not found by id while deleting tag")
+		return common.ErrNotFound
+	}
+
+	return nil
+}
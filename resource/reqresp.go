@@ -0,0 +1,599 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/policy"
+	"github.com/maxik12233/blog/types"
+)
+
+/* reqresp.go file stores golang structs, types and functions
+   to perform request response logic */
+
+type CreateArticleRequest struct {
+	types.Article
+}
+
+type CreateArticleResponse struct {
+	ID      uint   `json:"newid"`
+	Message string `json:"message"`
+}
+
+type DeleteArticleRequest struct {
+	ID    uint         `json:"id"`
+	Actor policy.Actor `json:"-"`
+}
+
+// UpdateArticleRequest changes article with JSON Merge Patch,
+// only header, short, long and tags can be changed
+type UpdateArticleRequest struct {
+	ID      uint            `json:"id"`
+	Actor   policy.Actor    `json:"-"`
+	Patch   json.RawMessage `json:"-"`
+	IfMatch []string        `json:"-"`
+}
+
+type GetArticleRequest struct {
+	ID    uint         `json:"id"`
+	Actor policy.Actor `json:"-"`
+}
+
+type GetArticleResponse struct {
+	Article *types.Article `json:"article"`
+}
+
+func (r GetArticleResponse) ETag() string {
+	return common.VersionTag(r.Article.Version)
+}
+
+// GetArticlesRequest lists articles with the status, not published
+// articles of other authors are listed only to moderators. Articles are
+// filtered by any or all of Tags slugs
+type GetArticlesRequest struct {
+	Amount uint                `json:"amount" validate:"max=100"`
+	Page   uint                `json:"page"`
+	Status types.ArticleStatus `json:"status" validate:"oneof=draft review published archived"`
+	Tags   []string            `json:"tags" validate:"max=10"`
+	Match  string              `json:"match" validate:"oneof=any all"`
+	Actor  policy.Actor        `json:"-"`
+}
+
+// ChangeArticleStatusRequest moves article to another status. Published
+// status with future publishat schedules publication instead
+type ChangeArticleStatusRequest struct {
+	ID        uint                `json:"-"`
+	Actor     policy.Actor        `json:"-"`
+	Status    types.ArticleStatus `json:"status" validate:"required,oneof=draft review published archived"`
+	PublishAt *time.Time          `json:"publishat"`
+	IfMatch   []string            `json:"-"`
+}
+
+type GetArticlesResponse struct {
+	Articles   []*types.Article `json:"articles"`
+	TotalCount int              `json:"totalCount"`
+}
+
+type GetRevisionsRequest struct {
+	ArticleID uint         `json:"id"`
+	Actor     policy.Actor `json:"-"`
+}
+
+type GetRevisionsResponse struct {
+	Revisions []*types.ArticleRevision `json:"revisions"`
+}
+
+type GetRevisionRequest struct {
+	ArticleID uint         `json:"id"`
+	Number    uint         `json:"number"`
+	Actor     policy.Actor `json:"-"`
+}
+
+type GetRevisionResponse struct {
+	Revision *types.ArticleRevision `json:"revision"`
+}
+
+type DiffRevisionsRequest struct {
+	ArticleID uint         `json:"id"`
+	From      uint         `json:"from"`
+	To        uint         `json:"to"`
+	Actor     policy.Actor `json:"-"`
+}
+
+// DiffRevisionsResponse has line-level diff of every text of two revisions
+type DiffRevisionsResponse struct {
+	From      uint              `json:"from"`
+	To        uint              `json:"to"`
+	Header    []common.DiffLine `json:"header"`
+	ShortText []common.DiffLine `json:"short"`
+	LongText  []common.DiffLine `json:"long"`
+}
+
+// RestoreRevisionRequest saves content of revision Number as a new revision
+type RestoreRevisionRequest struct {
+	ID      uint         `json:"id"`
+	Number  uint         `json:"number"`
+	Actor   policy.Actor `json:"-"`
+	IfMatch []string     `json:"-"`
+}
+
+type GetTagCloudResponse struct {
+	Tags []*types.TagCount `json:"tags"`
+}
+
+type CreateTagRequest struct {
+	types.Tag
+}
+
+type CreateTagResponse struct {
+	ID      uint   `json:"newid"`
+	Message string `json:"message"`
+}
+
+// UpdateTagRequest changes name and slug of tag with JSON Merge Patch
+type UpdateTagRequest struct {
+	ID    uint            `json:"id"`
+	Patch json.RawMessage `json:"-"`
+}
+
+type UpdateTagResponse struct {
+	Tag *types.Tag `json:"tag"`
+}
+
+type DeleteTagRequest struct {
+	ID uint `json:"id"`
+}
+
+type CreateCommentRequest struct {
+	types.Comment
+}
+
+type CreateCommentResponse struct {
+	ID      uint   `json:"newid"`
+	Message string `json:"message"`
+}
+
+type DeleteCommentRequest struct {
+	ID    uint         `json:"id"`
+	Actor policy.Actor `json:"-"`
+}
+
+// UpdateCommentRequest changes comment with JSON Merge Patch,
+// only text can be changed
+type UpdateCommentRequest struct {
+	ID      uint            `json:"id"`
+	Actor   policy.Actor    `json:"-"`
+	Patch   json.RawMessage `json:"-"`
+	IfMatch []string        `json:"-"`
+}
+
+type UpdateCommentResponse struct {
+	Comment *types.Comment `json:"comment"`
+}
+
+func (r UpdateCommentResponse) ETag() string {
+	return common.VersionTag(r.Comment.Version)
+}
+
+type GetArticleCommentsRequest struct {
+	ArticleID uint         `json:"artid"`
+	Actor     policy.Actor `json:"-"`
+}
+
+type GetArticleCommentsResponse struct {
+	Comments []*types.Comment `json:"comms"`
+}
+
+type ToggleLikeRequest struct {
+	types.Like
+	Flag  bool         `json:"flag"`
+	Actor policy.Actor `json:"-"`
+}
+
+func parseId(r *http.Request, name string) (uint, error) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params[name])
+	if err != nil || id <= 0 {
+		return 0, common.ErrInvalidId
+	}
+	return uint(id), nil
+}
+
+func decodeCreateArticleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateArticleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrBadRequest
+	}
+	req.ID = 0
+	req.AuthorID = userid
+	req.Version = 0
+	req.EditedAt = nil
+	req.PublishAt = nil
+	req.PublishedAt = nil
+	return req, nil
+}
+
+func decodeDeleteArticleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return DeleteArticleRequest{
+		ID:    id,
+		Actor: actor,
+	}, nil
+}
+
+func decodeUpdateArticleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	ifmatch, err := common.IfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodePatch(r)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateArticleRequest{
+		ID:      id,
+		Actor:   actor,
+		Patch:   patch,
+		IfMatch: ifmatch,
+	}, nil
+}
+
+func decodeGetArticleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticleRequest{
+		ID:    id,
+		Actor: actor,
+	}, nil
+}
+
+func decodeGetArticlesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	amount, err := strconv.Atoi(r.URL.Query().Get("amount"))
+	if err != nil || amount < 0 {
+		return nil, common.ErrBadRequest
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 0 {
+		return nil, common.ErrBadRequest
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req := GetArticlesRequest{
+		Amount: uint(amount),
+		Page:   uint(page),
+		Status: types.ArticlePublished,
+		Match:  "any",
+		Actor:  actor,
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		req.Status = types.ArticleStatus(status)
+	}
+	if match := r.URL.Query().Get("match"); match != "" {
+		req.Match = match
+	}
+	if tags := r.URL.Query().Get("tags"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			slug := common.Slugify(tag)
+			if slug == "" {
+				return nil, common.InvalidField("tags")
+			}
+			if !slices.Contains(req.Tags, slug) {
+				req.Tags = append(req.Tags, slug)
+			}
+		}
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeChangeArticleStatusRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	ifmatch, err := common.IfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	var req ChangeArticleStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	if req.PublishAt != nil && req.Status != types.ArticlePublished {
+		return nil, common.InvalidField("publishat")
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = id
+	req.Actor = actor
+	req.IfMatch = ifmatch
+	return req, nil
+}
+
+func decodeGetRevisionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetRevisionsRequest{
+		ArticleID: id,
+		Actor:     actor,
+	}, nil
+}
+
+func decodeGetRevisionRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	number, err := parseId(r, "number")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetRevisionRequest{
+		ArticleID: id,
+		Number:    number,
+		Actor:     actor,
+	}, nil
+}
+
+func decodeDiffRevisionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil || from <= 0 {
+		return nil, common.InvalidField("from")
+	}
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to <= 0 {
+		return nil, common.InvalidField("to")
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return DiffRevisionsRequest{
+		ArticleID: id,
+		From:      uint(from),
+		To:        uint(to),
+		Actor:     actor,
+	}, nil
+}
+
+func decodeRestoreRevisionRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	number, err := parseId(r, "number")
+	if err != nil {
+		return nil, err
+	}
+	ifmatch, err := common.IfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreRevisionRequest{
+		ID:      id,
+		Number:  number,
+		Actor:   actor,
+		IfMatch: ifmatch,
+	}, nil
+}
+
+func decodeGetTagCloudRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeCreateTagRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateTagRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.ID = 0
+	return req, nil
+}
+
+func decodeUpdateTagRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodePatch(r)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateTagRequest{
+		ID:    id,
+		Patch: patch,
+	}, nil
+}
+
+func decodeDeleteTagRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	return DeleteTagRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeCreateCommentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateCommentRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrBadRequest
+	}
+	req.ID = 0
+	req.AuthorID = userid
+	return req, nil
+}
+
+func decodeDeleteCommentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return DeleteCommentRequest{
+		ID:    id,
+		Actor: actor,
+	}, nil
+}
+
+func decodeUpdateCommentRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "id")
+	if err != nil {
+		return nil, err
+	}
+	ifmatch, err := common.IfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := decodePatch(r)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateCommentRequest{
+		ID:      id,
+		Actor:   actor,
+		Patch:   patch,
+		IfMatch: ifmatch,
+	}, nil
+}
+
+// decodePatch reads merge patch, which must be json object
+func decodePatch(r *http.Request) (json.RawMessage, error) {
+	var patch json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return nil, common.ErrBadRequest
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil || members == nil {
+		return nil, common.ErrBadRequest
+	}
+	return patch, nil
+}
+
+func decodeGetArticleCommentsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseId(r, "artid")
+	if err != nil {
+		return nil, err
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticleCommentsRequest{
+		ArticleID: id,
+		Actor:     actor,
+	}, nil
+}
+
+func decodeToggleLikeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ToggleLikeRequest
+	flag, err := strconv.ParseBool(r.URL.Query().Get("flag"))
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	err = json.NewDecoder(r.Body).Decode(&req.Like)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.ID = 0
+	req.UserID = actor.UserID
+	req.Flag = flag
+	req.Actor = actor
+	return req, nil
+}
+
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if common.WriteETag(ctx, w, response) {
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,13 @@
+package resource
+
+import "time"
+
+// RunPublishScheduler publishes scheduled articles every interval,
+// it never returns so it must be run in its own goroutine
+func RunPublishScheduler(s ResourceService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_ = s.PublishScheduled()
+	}
+}
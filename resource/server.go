@@ -0,0 +1,155 @@
+package resource
+
+import (
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/types"
+)
+
+func CreateNewServer(rg *mux.Router, endpoints ResourceEndpoints) {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(common.EncodeError),
+		httptransport.ServerBefore(common.PopulateIfNoneMatch),
+	}
+
+	resgroup := rg.PathPrefix("/res").Subrouter()
+	resgroup.Use(middleware.LoggingMiddleware)
+	require := middleware.RequirePermissions
+	requireAny := middleware.RequireAnyPermission
+
+	resgroup.Methods("POST").Path("/like").Handler(require(types.PermLikeCreate)(httptransport.NewServer(
+		endpoints.ToggleLike,
+		decodeToggleLikeRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("POST").Path("/art/").Handler(require(types.PermArticleCreate)(httptransport.NewServer(
+		endpoints.CreateArticle,
+		decodeCreateArticleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("DELETE").Path("/art/{id}").Handler(requireAny(types.PermArticleDeleteOwn, types.PermArticleDeleteAny)(httptransport.NewServer(
+		endpoints.DeleteArticle,
+		decodeDeleteArticleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("PATCH").Path("/art/{id}").Handler(requireAny(types.PermArticleUpdateOwn, types.PermArticleUpdateAny)(httptransport.NewServer(
+		endpoints.UpdateArticle,
+		decodeUpdateArticleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("POST").Path("/art/{id}/status").Handler(requireAny(types.PermArticleUpdateOwn, types.PermArticlePublish)(httptransport.NewServer(
+		endpoints.ChangeArticleStatus,
+		decodeChangeArticleStatusRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/art/{id}/revisions").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.GetRevisions,
+		decodeGetRevisionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/art/{id}/revisions/{number}").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.GetRevision,
+		decodeGetRevisionRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("POST").Path("/art/{id}/revisions/{number}/restore").Handler(requireAny(types.PermArticleUpdateOwn, types.PermArticleUpdateAny)(httptransport.NewServer(
+		endpoints.RestoreRevision,
+		decodeRestoreRevisionRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/art/{id}/diff").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.DiffRevisions,
+		decodeDiffRevisionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/art/{id}").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.GetOneArticle,
+		decodeGetArticleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/art/").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.GetArticles,
+		decodeGetArticlesRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("POST").Path("/comm/").Handler(require(types.PermCommentCreate)(httptransport.NewServer(
+		endpoints.CreateComment,
+		decodeCreateCommentRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("DELETE").Path("/comm/{id}").Handler(requireAny(types.PermCommentDeleteOwn, types.PermCommentDeleteAny)(httptransport.NewServer(
+		endpoints.DeleteComment,
+		decodeDeleteCommentRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("PATCH").Path("/comm/{id}").Handler(requireAny(types.PermCommentUpdateOwn, types.PermCommentUpdateAny)(httptransport.NewServer(
+		endpoints.UpdateComment,
+		decodeUpdateCommentRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/comm/{artid}").Handler(require(types.PermCommentRead)(httptransport.NewServer(
+		endpoints.GetArticleComments,
+		decodeGetArticleCommentsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("GET").Path("/tag/").Handler(require(types.PermArticleRead)(httptransport.NewServer(
+		endpoints.GetTagCloud,
+		decodeGetTagCloudRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("POST").Path("/tag/").Handler(require(types.PermTagManage)(httptransport.NewServer(
+		endpoints.CreateTag,
+		decodeCreateTagRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("PATCH").Path("/tag/{id}").Handler(require(types.PermTagManage)(httptransport.NewServer(
+		endpoints.UpdateTag,
+		decodeUpdateTagRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	resgroup.Methods("DELETE").Path("/tag/{id}").Handler(require(types.PermTagManage)(httptransport.NewServer(
+		endpoints.DeleteTag,
+		decodeDeleteTagRequest,
+		encodeResponse,
+		options...,
+	)))
+
+}
@@ -0,0 +1,701 @@
+package resource
+
+import (
+	"slices"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/policy"
+	"github.com/maxik12233/blog/types"
+	"go.uber.org/zap"
+)
+
+type ResourceService interface {
+	CreateArticle(art *types.Article) (uint, error)
+	DeleteArticle(req *DeleteArticleRequest) error
+	UpdateArticle(req *UpdateArticleRequest) (*types.Article, error)
+	GetArticles(req *GetArticlesRequest) ([]*types.Article, int, error)
+	GetOneArticle(id uint, actor policy.Actor) (*types.Article, error)
+	ChangeArticleStatus(req *ChangeArticleStatusRequest) (*types.Article, error)
+	PublishScheduled() error
+
+	GetRevisions(artid uint, actor policy.Actor) ([]*types.ArticleRevision, error)
+	GetRevision(artid uint, number uint, actor policy.Actor) (*types.ArticleRevision, error)
+	DiffRevisions(artid uint, from uint, to uint, actor policy.Actor) (*types.ArticleRevision, *types.ArticleRevision, error)
+	RestoreRevision(req *RestoreRevisionRequest) (*types.Article, error)
+
+	CreateComment(comm *types.Comment) (uint, error)
+	DeleteComment(req *DeleteCommentRequest) error
+	UpdateComment(req *UpdateCommentRequest) (*types.Comment, error)
+	GetArticleComments(artid uint, actor policy.Actor) ([]*types.Comment, error)
+
+	ToggleLike(like *types.Like, flag bool, actor policy.Actor) error
+
+	GetTagCloud() ([]*types.TagCount, error)
+	CreateTag(tag *types.Tag) (uint, error)
+	UpdateTag(req *UpdateTagRequest) (*types.Tag, error)
+	DeleteTag(id uint) error
+}
+
+// articleTransitions lists allowed moves between article statuses, value
+// tells if the author may make the move. Moderators may make any of them
+var articleTransitions = map[types.ArticleStatus]map[types.ArticleStatus]bool{
+	types.ArticleDraft:     {types.ArticleInReview: true, types.ArticlePublished: false},
+	types.ArticleInReview:  {types.ArticleDraft: true, types.ArticlePublished: false},
+	types.ArticlePublished: {types.ArticleDraft: true, types.ArticleArchived: true},
+	types.ArticleArchived:  {types.ArticleDraft: true, types.ArticlePublished: false},
+}
+
+// canSee tells if actor may read the article, not published articles
+// are visible only to their author and moderators
+func canSee(actor policy.Actor, art *types.Article) bool {
+	return art.Status == types.ArticlePublished || art.AuthorID == actor.UserID || actor.Can(types.PermArticlePublish)
+}
+
+// canSeeHistory tells if actor may read revisions of the article, they
+// may contain removed text, so only author and moderators see them
+func canSeeHistory(actor policy.Actor, art *types.Article) bool {
+	return art.AuthorID == actor.UserID || actor.Can(types.PermArticlePublish)
+}
+
+type ResourceServiceImpl struct {
+	repo   ResourceRepository
+	policy policy.Policy
+	logger *zap.Logger
+}
+
+func NewResourceService(repo ResourceRepository, policy policy.Policy, logger *zap.Logger) ResourceService {
+	return &ResourceServiceImpl{
+		repo:   repo,
+		policy: policy,
+		logger: logger,
+	}
+}
+
+func (s *ResourceServiceImpl) CreateArticle(art *types.Article) (uint, error) {
+	s.logger.Info("In CreateArticle")
+
+	// New article is visible only to its author until it is published
+	art.Status = types.ArticleDraft
+	tags, err := s.findTags(art.Tags)
+	if err != nil {
+		return 0, err
+	}
+	art.Tags = tags
+
+	id, err := s.repo.CreateArticle(art)
+	if err != nil {
+		s.logger.Error("Error while creating article", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *ResourceServiceImpl) DeleteArticle(req *DeleteArticleRequest) error {
+	s.logger.Info("In DeleteArticle")
+
+	art, err := s.repo.GetOneArticle(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting article to delete", zap.Error(err))
+		return err
+	}
+
+	err = s.policy.Enforce(req.Actor, art.AuthorID, types.PermArticleDeleteOwn, types.PermArticleDeleteAny, "article:delete", art.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteArticle(art.ID)
+	if err != nil {
+		s.logger.Error("Error while deleting article", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UpdateArticle applies merge patch to the article. Author needs permission
+// to update own articles, anybody else needs override
+func (s *ResourceServiceImpl) UpdateArticle(req *UpdateArticleRequest) (*types.Article, error) {
+	s.logger.Info("In UpdateArticle")
+
+	art, err := s.repo.GetOneArticle(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting article to update", zap.Error(err))
+		return nil, err
+	}
+
+	err = s.policy.Enforce(req.Actor, art.AuthorID, types.PermArticleUpdateOwn, types.PermArticleUpdateAny, "article:update", art.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !common.MatchTag(req.IfMatch, common.VersionTag(art.Version)) {
+		s.logger.Info("Article was changed since client got it", zap.Uint("id", art.ID))
+		return nil, common.ErrPreconditionFailed
+	}
+
+	var patched types.Article
+	if err := common.ApplyPatch(art, req.Patch, &patched); err != nil {
+		return nil, err
+	}
+	tags, err := s.findTags(patched.Tags)
+	if err != nil {
+		return nil, err
+	}
+	textChanged := patched.Header != art.Header || patched.ShortText != art.ShortText || patched.LongText != art.LongText
+	if !textChanged && sameTags(tags, art.Tags) {
+		return art, nil
+	}
+	art.Header = patched.Header
+	art.ShortText = patched.ShortText
+	art.LongText = patched.LongText
+	art.Tags = tags
+	if err := common.Validate(art); err != nil {
+		return nil, err
+	}
+
+	var rev *types.ArticleRevision
+	if textChanged {
+		rev = newRevision(art, req.Actor, nil)
+	}
+	if err := s.saveArticle(art, rev); err != nil {
+		return nil, err
+	}
+	return art, nil
+}
+
+// newRevision returns revision with current content of art made by actor
+func newRevision(art *types.Article, actor policy.Actor, restoredFrom *uint) *types.ArticleRevision {
+	return &types.ArticleRevision{
+		Header:       art.Header,
+		ShortText:    art.ShortText,
+		LongText:     art.LongText,
+		AuthorID:     actor.UserID,
+		RestoredFrom: restoredFrom,
+	}
+}
+
+// saveArticle saves changed article together with rev, rev is nil
+// if texts weren't changed
+func (s *ResourceServiceImpl) saveArticle(art *types.Article, rev *types.ArticleRevision) error {
+	now := time.Now()
+	art.EditedAt = &now
+	if rev != nil {
+		rev.CreatedAt = now
+	}
+	err := s.repo.UpdateArticle(art, rev)
+	if err != nil {
+		s.logger.Error("Error while updating article", zap.Error(err))
+		return err
+	}
+	art.Version++
+
+	return nil
+}
+
+// getHistory returns article whose revisions actor wants to read
+func (s *ResourceServiceImpl) getHistory(artid uint, actor policy.Actor) (*types.Article, error) {
+	art, err := s.repo.GetOneArticle(artid)
+	if err != nil {
+		s.logger.Error("Error while getting article of revisions", zap.Error(err))
+		return nil, err
+	}
+	if !canSeeHistory(actor, art) {
+		s.logger.Info("Article revisions are hidden from actor", zap.Uint("id", art.ID), zap.Uint("actorid", actor.UserID))
+		return nil, common.ErrNotFound
+	}
+	return art, nil
+}
+
+func (s *ResourceServiceImpl) GetRevisions(artid uint, actor policy.Actor) ([]*types.ArticleRevision, error) {
+	s.logger.Info("In GetRevisions")
+
+	if _, err := s.getHistory(artid, actor); err != nil {
+		return nil, err
+	}
+
+	revs, err := s.repo.GetRevisions(artid)
+	if err != nil {
+		s.logger.Error("Error while getting article revisions", zap.Error(err))
+		return nil, err
+	}
+
+	return revs, nil
+}
+
+func (s *ResourceServiceImpl) GetRevision(artid uint, number uint, actor policy.Actor) (*types.ArticleRevision, error) {
+	s.logger.Info("In GetRevision")
+
+	if _, err := s.getHistory(artid, actor); err != nil {
+		return nil, err
+	}
+
+	rev, err := s.repo.GetRevision(artid, number)
+	if err != nil {
+		s.logger.Error("Error while getting article revision", zap.Error(err))
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// DiffRevisions returns two revisions of the article which are compared
+func (s *ResourceServiceImpl) DiffRevisions(artid uint, from uint, to uint, actor policy.Actor) (*types.ArticleRevision, *types.ArticleRevision, error) {
+	s.logger.Info("In DiffRevisions")
+
+	if _, err := s.getHistory(artid, actor); err != nil {
+		return nil, nil, err
+	}
+
+	fromRev, err := s.repo.GetRevision(artid, from)
+	if err != nil {
+		s.logger.Error("Error while getting article revision", zap.Error(err))
+		return nil, nil, err
+	}
+	toRev, err := s.repo.GetRevision(artid, to)
+	if err != nil {
+		s.logger.Error("Error while getting article revision", zap.Error(err))
+		return nil, nil, err
+	}
+
+	return fromRev, toRev, nil
+}
+
+// RestoreRevision saves content of old revision as a new revision, it is
+// allowed to those who can update the article
+func (s *ResourceServiceImpl) RestoreRevision(req *RestoreRevisionRequest) (*types.Article, error) {
+	s.logger.Info("In RestoreRevision")
+
+	art, err := s.repo.GetOneArticle(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting article to restore", zap.Error(err))
+		return nil, err
+	}
+
+	err = s.policy.Enforce(req.Actor, art.AuthorID, types.PermArticleUpdateOwn, types.PermArticleUpdateAny, "article:restore", art.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !common.MatchTag(req.IfMatch, common.VersionTag(art.Version)) {
+		s.logger.Info("Article was changed since client got it", zap.Uint("id", art.ID))
+		return nil, common.ErrPreconditionFailed
+	}
+
+	rev, err := s.repo.GetRevision(art.ID, req.Number)
+	if err != nil {
+		s.logger.Error("Error while getting revision to restore", zap.Error(err))
+		return nil, err
+	}
+	if rev.Header == art.Header && rev.ShortText == art.ShortText && rev.LongText == art.LongText {
+		return art, nil
+	}
+	art.Header = rev.Header
+	art.ShortText = rev.ShortText
+	art.LongText = rev.LongText
+
+	if err := s.saveArticle(art, newRevision(art, req.Actor, &rev.Number)); err != nil {
+		return nil, err
+	}
+	return art, nil
+}
+
+// GetOneArticle returns article if actor may see it, hidden article is not found
+func (s *ResourceServiceImpl) GetOneArticle(id uint, actor policy.Actor) (*types.Article, error) {
+	s.logger.Info("In GetOneArticle")
+
+	art, err := s.repo.GetOneArticle(id)
+	if err != nil {
+		s.logger.Error("Error while getting one article by id", zap.Error(err))
+		return nil, err
+	}
+	if !canSee(actor, art) {
+		s.logger.Info("Article is hidden from actor", zap.Uint("id", art.ID), zap.Uint("actorid", actor.UserID))
+		return nil, common.ErrNotFound
+	}
+
+	return art, nil
+}
+
+// GetArticles lists articles with requested status. Not published articles
+// are listed to moderators, other users get only their own ones
+func (s *ResourceServiceImpl) GetArticles(req *GetArticlesRequest) ([]*types.Article, int, error) {
+	s.logger.Info("In GetArticles")
+
+	var authorid uint
+	if req.Status != types.ArticlePublished && !req.Actor.Can(types.PermArticlePublish) {
+		authorid = req.Actor.UserID
+	}
+
+	arts, count, err := s.repo.GetArticles(req, authorid)
+	if err != nil {
+		s.logger.Error("Error while getting articles", zap.Error(err))
+		return nil, 0, err
+	}
+
+	return arts, count, nil
+}
+
+// ChangeArticleStatus moves article by articleTransitions. Moving to published
+// status with future publishat schedules publication, article keeps its
+// status until scheduler publishes it. Any other move cancels schedule
+func (s *ResourceServiceImpl) ChangeArticleStatus(req *ChangeArticleStatusRequest) (*types.Article, error) {
+	s.logger.Info("In ChangeArticleStatus")
+
+	art, err := s.repo.GetOneArticle(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting article to change status", zap.Error(err))
+		return nil, err
+	}
+	if !canSee(req.Actor, art) {
+		s.logger.Info("Article is hidden from actor", zap.Uint("id", art.ID), zap.Uint("actorid", req.Actor.UserID))
+		return nil, common.ErrNotFound
+	}
+
+	byAuthor, ok := articleTransitions[art.Status][req.Status]
+	if !ok {
+		s.logger.Info("Not allowed article status transition", zap.Uint("id", art.ID), zap.String("from", string(art.Status)), zap.String("to", string(req.Status)))
+		return nil, common.ErrInvalidTransition
+	}
+	isAuthor := art.AuthorID == req.Actor.UserID && req.Actor.Can(types.PermArticleUpdateOwn)
+	if !req.Actor.Can(types.PermArticlePublish) && !(byAuthor && isAuthor) {
+		s.logger.Info("Article status transition is forbidden", zap.Uint("id", art.ID), zap.Uint("actorid", req.Actor.UserID), zap.String("to", string(req.Status)))
+		return nil, common.ErrForbidden
+	}
+	if !common.MatchTag(req.IfMatch, common.VersionTag(art.Version)) {
+		s.logger.Info("Article was changed since client got it", zap.Uint("id", art.ID))
+		return nil, common.ErrPreconditionFailed
+	}
+
+	from := art.Status
+	now := time.Now()
+	if req.PublishAt != nil && req.PublishAt.After(now) {
+		art.PublishAt = req.PublishAt
+	} else {
+		art.Status = req.Status
+		art.PublishAt = nil
+		if art.Status == types.ArticlePublished {
+			art.PublishedAt = &now
+		}
+	}
+
+	err = s.repo.ChangeArticleStatus(art, from)
+	if err != nil {
+		s.logger.Error("Error while changing article status", zap.Error(err))
+		return nil, err
+	}
+	art.Version++
+
+	return art, nil
+}
+
+// PublishScheduled publishes articles whose publication time has come
+func (s *ResourceServiceImpl) PublishScheduled() error {
+	count, err := s.repo.PublishScheduled(time.Now())
+	if err != nil {
+		s.logger.Error("Error while publishing scheduled articles", zap.Error(err))
+		return err
+	}
+	if count > 0 {
+		s.logger.Info("Scheduled articles published", zap.Int64("count", count))
+	}
+
+	return nil
+}
+
+func (s *ResourceServiceImpl) CreateComment(comm *types.Comment) (uint, error) {
+	s.logger.Info("In CreateComment")
+
+	art, err := s.repo.GetOneArticle(comm.ArticleID)
+	if err != nil {
+		s.logger.Error("Error while getting commented article", zap.Error(err))
+		return 0, err
+	}
+	// Only published articles can be commented
+	if art.Status != types.ArticlePublished {
+		s.logger.Info("Commented article is not published", zap.Uint("id", art.ID))
+		return 0, common.ErrNotFound
+	}
+
+	id, err := s.repo.CreateComment(comm)
+	if err != nil {
+		s.logger.Error("Error while creating comment", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *ResourceServiceImpl) DeleteComment(req *DeleteCommentRequest) error {
+	s.logger.Info("In DeleteComment")
+
+	comm, err := s.repo.GetOneComment(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting comment to delete", zap.Error(err))
+		return err
+	}
+
+	err = s.policy.Enforce(req.Actor, comm.AuthorID, types.PermCommentDeleteOwn, types.PermCommentDeleteAny, "comment:delete", comm.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteComment(comm.ID)
+	if err != nil {
+		s.logger.Error("Error while deleting comment", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// UpdateComment applies merge patch to the comment. Author needs permission
+// to write comments, anybody else needs override
+func (s *ResourceServiceImpl) UpdateComment(req *UpdateCommentRequest) (*types.Comment, error) {
+	s.logger.Info("In UpdateComment")
+
+	comm, err := s.repo.GetOneComment(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting comment to update", zap.Error(err))
+		return nil, err
+	}
+
+	err = s.policy.Enforce(req.Actor, comm.AuthorID, types.PermCommentUpdateOwn, types.PermCommentUpdateAny, "comment:update", comm.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !common.MatchTag(req.IfMatch, common.VersionTag(comm.Version)) {
+		s.logger.Info("Comment was changed since client got it", zap.Uint("id", comm.ID))
+		return nil, common.ErrPreconditionFailed
+	}
+
+	var patched types.Comment
+	if err := common.ApplyPatch(comm, req.Patch, &patched); err != nil {
+		return nil, err
+	}
+	if patched.RawText == comm.RawText {
+		return comm, nil
+	}
+	comm.RawText = patched.RawText
+	if err := common.Validate(comm); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	comm.EditedAt = &now
+	err = s.repo.UpdateComment(comm)
+	if err != nil {
+		s.logger.Error("Error while updating comment", zap.Error(err))
+		return nil, err
+	}
+	comm.Version++
+
+	return comm, nil
+}
+
+func (s *ResourceServiceImpl) GetArticleComments(artid uint, actor policy.Actor) ([]*types.Comment, error) {
+	s.logger.Info("In GetArticleComments")
+
+	if _, err := s.GetOneArticle(artid, actor); err != nil {
+		return nil, err
+	}
+
+	comms, err := s.repo.GetArticleComments(artid)
+	if err != nil {
+		s.logger.Error("Error while getting article comments", zap.Error(err))
+		return nil, err
+	}
+
+	return comms, nil
+}
+
+func (s *ResourceServiceImpl) ToggleLike(like *types.Like, flag bool, actor policy.Actor) error {
+	s.logger.Info("In ToggleLike")
+
+	// Liked comment is visible only with its article
+	artid := like.ArticleID
+	if like.CommentID != 0 {
+		comm, err := s.repo.GetOneComment(like.CommentID)
+		if err != nil {
+			s.logger.Error("Error while getting liked comment", zap.Error(err))
+			return err
+		}
+		artid = comm.ArticleID
+	}
+	art, err := s.repo.GetOneArticle(artid)
+	if err != nil {
+		s.logger.Error("Error while getting liked article", zap.Error(err))
+		return err
+	}
+	if !canSee(actor, art) {
+		s.logger.Info("Article is hidden from actor", zap.Uint("id", art.ID), zap.Uint("actorid", actor.UserID))
+		return common.ErrNotFound
+	}
+
+	if !flag {
+		err := s.repo.DeleteLike(like)
+		if err != nil {
+			s.logger.Error("Error while deleting like", zap.Error(err))
+			return err
+		}
+		return nil
+	}
+
+	count, err := s.repo.CountLikes(like)
+	if err != nil {
+		s.logger.Error("Error while counting likes", zap.Error(err))
+		return err
+	}
+	if count > 0 {
+		s.logger.Info("This user already like that resource")
+		return common.ErrAlreadyExists
+	}
+
+	err = s.repo.CreateLike(like)
+	if err != nil {
+		s.logger.Error("Error while creating like", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// findTags returns existing tags with slugs of tags, every slug is normalized
+// and must belong to a tag, because only moderators create tags
+func (s *ResourceServiceImpl) findTags(tags []types.Tag) ([]types.Tag, error) {
+	slugs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		slug := common.Slugify(tag.Slug)
+		if slug == "" {
+			return nil, common.InvalidField("tags")
+		}
+		if !slices.Contains(slugs, slug) {
+			slugs = append(slugs, slug)
+		}
+	}
+	if len(slugs) == 0 {
+		return []types.Tag{}, nil
+	}
+
+	found, err := s.repo.GetTagsBySlugs(slugs)
+	if err != nil {
+		s.logger.Error("Error while getting tags by slugs", zap.Error(err))
+		return nil, err
+	}
+	if len(found) != len(slugs) {
+		s.logger.Info("Unknown tags of article", zap.Strings("slugs", slugs))
+		return nil, common.InvalidField("tags")
+	}
+
+	return found, nil
+}
+
+func sameTags(a []types.Tag, b []types.Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, tag := range a {
+		if !slices.ContainsFunc(b, func(other types.Tag) bool { return other.ID == tag.ID }) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetTagCloud returns all tags with counts of their published articles
+func (s *ResourceServiceImpl) GetTagCloud() ([]*types.TagCount, error) {
+	s.logger.Info("In GetTagCloud")
+
+	tags, err := s.repo.GetTagCloud()
+	if err != nil {
+		s.logger.Error("Error while getting tag cloud", zap.Error(err))
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// CreateTag creates tag, slug is made of the given slug or of the name
+func (s *ResourceServiceImpl) CreateTag(tag *types.Tag) (uint, error) {
+	s.logger.Info("In CreateTag")
+
+	if tag.Slug == "" {
+		tag.Slug = tag.Name
+	}
+	tag.Slug = common.Slugify(tag.Slug)
+	if err := s.checkSlug(tag); err != nil {
+		return 0, err
+	}
+
+	id, err := s.repo.CreateTag(tag)
+	if err != nil {
+		s.logger.Error("Error while creating tag", zap.Error(err))
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// UpdateTag applies merge patch to name and slug of the tag
+func (s *ResourceServiceImpl) UpdateTag(req *UpdateTagRequest) (*types.Tag, error) {
+	s.logger.Info("In UpdateTag")
+
+	tag, err := s.repo.GetTag(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting tag to update", zap.Error(err))
+		return nil, err
+	}
+
+	var patched types.Tag
+	if err := common.ApplyPatch(tag, req.Patch, &patched); err != nil {
+		return nil, err
+	}
+	patched.ID = tag.ID
+	patched.Slug = common.Slugify(patched.Slug)
+	if patched == *tag {
+		return tag, nil
+	}
+	if err := common.Validate(patched); err != nil {
+		return nil, err
+	}
+	if err := s.checkSlug(&patched); err != nil {
+		return nil, err
+	}
+
+	err = s.repo.UpdateTag(&patched)
+	if err != nil {
+		s.logger.Error("Error while updating tag", zap.Error(err))
+		return nil, err
+	}
+
+	return &patched, nil
+}
+
+// checkSlug checks that slug of tag is valid and isn't taken by other tag
+func (s *ResourceServiceImpl) checkSlug(tag *types.Tag) error {
+	if tag.Slug == "" {
+		return common.InvalidField("slug")
+	}
+	count, err := s.repo.CountTags(tag.Slug, tag.ID)
+	if err != nil {
+		s.logger.Error("Error while counting tags", zap.Error(err))
+		return err
+	}
+	if count > 0 {
+		s.logger.Info("Tag with this slug already exists", zap.String("slug", tag.Slug))
+		return common.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *ResourceServiceImpl) DeleteTag(id uint) error {
+	s.logger.Info("In DeleteTag")
+
+	err := s.repo.DeleteTag(id)
+	if err != nil {
+		s.logger.Error("Error while deleting tag", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
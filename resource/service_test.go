@@ -0,0 +1,332 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/policy"
+	"github.com/maxik12233/blog/types"
+	"go.uber.org/zap"
+)
+
+// memRepo keeps articles in memory for service tests. Only methods which
+// tests call are implemented, others panic on the nil embedded interface
+type memRepo struct {
+	ResourceRepository
+
+	articles  map[uint]*types.Article
+	revisions []*types.ArticleRevision
+	comments  map[uint]*types.Comment
+	likes     []types.Like
+}
+
+func (r *memRepo) GetOneArticle(id uint) (*types.Article, error) {
+	art, ok := r.articles[id]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+	copied := *art
+	return &copied, nil
+}
+
+func (r *memRepo) UpdateArticle(art *types.Article, rev *types.ArticleRevision) error {
+	stored, ok := r.articles[art.ID]
+	if !ok || stored.Version != art.Version {
+		return common.ErrPreconditionFailed
+	}
+	copied := *art
+	copied.Version++
+	r.articles[art.ID] = &copied
+	if rev == nil {
+		return nil
+	}
+
+	var last uint
+	for _, val := range r.revisions {
+		if val.ArticleID == art.ID {
+			last = max(last, val.Number)
+		}
+	}
+	rev.ArticleID = art.ID
+	rev.Number = last + 1
+	r.revisions = append(r.revisions, rev)
+	return nil
+}
+
+func (r *memRepo) ChangeArticleStatus(art *types.Article, from types.ArticleStatus) error {
+	stored, ok := r.articles[art.ID]
+	if !ok || stored.Version != art.Version || stored.Status != from {
+		return common.ErrPreconditionFailed
+	}
+	copied := *art
+	copied.Version++
+	r.articles[art.ID] = &copied
+	return nil
+}
+
+func (r *memRepo) GetRevision(artid uint, number uint) (*types.ArticleRevision, error) {
+	for _, rev := range r.revisions {
+		if rev.ArticleID == artid && rev.Number == number {
+			copied := *rev
+			return &copied, nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func (r *memRepo) GetOneComment(id uint) (*types.Comment, error) {
+	comm, ok := r.comments[id]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+	copied := *comm
+	return &copied, nil
+}
+
+func (r *memRepo) UpdateComment(comm *types.Comment) error {
+	stored, ok := r.comments[comm.ID]
+	if !ok || stored.Version != comm.Version {
+		return common.ErrPreconditionFailed
+	}
+	copied := *comm
+	copied.Version++
+	r.comments[comm.ID] = &copied
+	return nil
+}
+
+func (r *memRepo) CountLikes(like *types.Like) (int64, error) {
+	var count int64
+	for _, val := range r.likes {
+		if val.UserID == like.UserID && val.ArticleID == like.ArticleID && val.CommentID == like.CommentID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memRepo) CreateLike(like *types.Like) error {
+	r.likes = append(r.likes, *like)
+	return nil
+}
+
+const authorID = 1
+
+// newRevisionsRepo returns article of authorID with two revisions, the second one is current
+func newRevisionsRepo() *memRepo {
+	return &memRepo{
+		articles: map[uint]*types.Article{
+			1: {ID: 1, Header: "second", ShortText: "short 2", LongText: "long 2", Version: 2, AuthorID: authorID, Status: types.ArticlePublished},
+		},
+		revisions: []*types.ArticleRevision{
+			{ArticleID: 1, Number: 1, Header: "first", ShortText: "short 1", LongText: "long 1", AuthorID: authorID},
+			{ArticleID: 1, Number: 2, Header: "second", ShortText: "short 2", LongText: "long 2", AuthorID: authorID},
+		},
+	}
+}
+
+func newTestService(repo ResourceRepository) *ResourceServiceImpl {
+	logger := zap.NewNop()
+	// Policy needs db only to write audit entries of overrides
+	return NewResourceService(repo, policy.NewPolicy(nil, logger), logger).(*ResourceServiceImpl)
+}
+
+func TestRestoreRevision(t *testing.T) {
+	repo := newRevisionsRepo()
+	s := newTestService(repo)
+	author := policy.Actor{UserID: authorID, Permissions: types.DefaultRolePermissions[types.RoleCommon]}
+
+	art, err := s.RestoreRevision(&RestoreRevisionRequest{ID: 1, Number: 1, Actor: author, IfMatch: []string{common.VersionTag(2)}})
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if art.Header != "first" || art.ShortText != "short 1" || art.LongText != "long 1" || art.Version != 3 {
+		t.Fatalf("unexpected restored article %+v", art)
+	}
+	stored, _ := repo.GetOneArticle(1)
+	if stored.Header != "first" || stored.Version != 3 || stored.EditedAt == nil {
+		t.Fatalf("restored article is not saved: %+v", stored)
+	}
+
+	rev, err := repo.GetRevision(1, 3)
+	if err != nil {
+		t.Fatalf("no new revision: %v", err)
+	}
+	if rev.Header != "first" || rev.LongText != "long 1" || rev.AuthorID != authorID || rev.RestoredFrom == nil || *rev.RestoredFrom != 1 {
+		t.Fatalf("unexpected new revision %+v", rev)
+	}
+	if first, _ := repo.GetRevision(1, 1); first.Header != "first" || first.RestoredFrom != nil {
+		t.Fatalf("restored revision is changed: %+v", first)
+	}
+}
+
+func TestRestoreRevisionOfCurrentContent(t *testing.T) {
+	repo := newRevisionsRepo()
+	s := newTestService(repo)
+	author := policy.Actor{UserID: authorID, Permissions: types.DefaultRolePermissions[types.RoleCommon]}
+
+	art, err := s.RestoreRevision(&RestoreRevisionRequest{ID: 1, Number: 2, Actor: author, IfMatch: []string{"*"}})
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if art.Version != 2 || len(repo.revisions) != 2 {
+		t.Fatalf("revision with current content is saved again: version %d, %d revisions", art.Version, len(repo.revisions))
+	}
+}
+
+func TestRestoreRevisionIsRejected(t *testing.T) {
+	author := policy.Actor{UserID: authorID, Permissions: types.DefaultRolePermissions[types.RoleCommon]}
+	tests := []struct {
+		name string
+		req  RestoreRevisionRequest
+		want error
+	}{
+		{
+			name: "stale version",
+			req:  RestoreRevisionRequest{ID: 1, Number: 1, Actor: author, IfMatch: []string{common.VersionTag(1)}},
+			want: common.ErrPreconditionFailed,
+		},
+		{
+			name: "weak tag",
+			req:  RestoreRevisionRequest{ID: 1, Number: 1, Actor: author, IfMatch: []string{"W/" + common.VersionTag(2)}},
+			want: common.ErrPreconditionFailed,
+		},
+		{
+			name: "unknown revision",
+			req:  RestoreRevisionRequest{ID: 1, Number: 5, Actor: author, IfMatch: []string{common.VersionTag(2)}},
+			want: common.ErrNotFound,
+		},
+		{
+			name: "unknown article",
+			req:  RestoreRevisionRequest{ID: 2, Number: 1, Actor: author, IfMatch: []string{common.VersionTag(2)}},
+			want: common.ErrNotFound,
+		},
+		{
+			name: "other user",
+			req: RestoreRevisionRequest{ID: 1, Number: 1, IfMatch: []string{common.VersionTag(2)},
+				Actor: policy.Actor{UserID: 2, Permissions: types.DefaultRolePermissions[types.RoleCommon]}},
+			want: common.ErrForbidden,
+		},
+		{
+			name: "author without permissions",
+			req:  RestoreRevisionRequest{ID: 1, Number: 1, IfMatch: []string{common.VersionTag(2)}, Actor: policy.Actor{UserID: authorID}},
+			want: common.ErrForbidden,
+		},
+		{
+			name: "author who can only create articles",
+			req: RestoreRevisionRequest{ID: 1, Number: 1, IfMatch: []string{common.VersionTag(2)},
+				Actor: policy.Actor{UserID: authorID, Permissions: []types.Permission{types.PermArticleCreate}}},
+			want: common.ErrForbidden,
+		},
+		{
+			name: "moderator without override reason",
+			req: RestoreRevisionRequest{ID: 1, Number: 1, IfMatch: []string{common.VersionTag(2)},
+				Actor: policy.Actor{UserID: 2, Permissions: types.DefaultRolePermissions[types.RoleModerator]}},
+			want: common.ErrForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRevisionsRepo()
+			s := newTestService(repo)
+
+			if _, err := s.RestoreRevision(&tt.req); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if stored, _ := repo.GetOneArticle(1); stored.Header != "second" || stored.Version != 2 || len(repo.revisions) != 2 {
+				t.Fatalf("article is changed: %+v, %d revisions", stored, len(repo.revisions))
+			}
+		})
+	}
+}
+
+func TestUpdateCommentNeedsUpdateOwn(t *testing.T) {
+	tests := []struct {
+		name  string
+		perms []types.Permission
+		want  error
+	}{
+		{name: "common role", perms: types.DefaultRolePermissions[types.RoleCommon]},
+		{name: "only create", perms: []types.Permission{types.PermCommentCreate}, want: common.ErrForbidden},
+		{name: "only update own", perms: []types.Permission{types.PermCommentUpdateOwn}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memRepo{comments: map[uint]*types.Comment{
+				1: {ID: 1, RawText: "old", Version: 1, AuthorID: authorID, ArticleID: 1},
+			}}
+			s := newTestService(repo)
+
+			_, err := s.UpdateComment(&UpdateCommentRequest{ID: 1, Patch: []byte(`{"text":"new"}`), IfMatch: []string{"*"},
+				Actor: policy.Actor{UserID: authorID, Permissions: tt.perms}})
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if stored, _ := repo.GetOneComment(1); (stored.RawText == "new") != (tt.want == nil) {
+				t.Fatalf("unexpected stored comment %+v", stored)
+			}
+		})
+	}
+}
+
+func TestChangeArticleStatusChecksVersion(t *testing.T) {
+	author := policy.Actor{UserID: authorID, Permissions: types.DefaultRolePermissions[types.RoleCommon]}
+	tests := []struct {
+		name    string
+		ifmatch []string
+		want    error
+	}{
+		{name: "current version", ifmatch: []string{common.VersionTag(2)}},
+		{name: "any version", ifmatch: []string{"*"}},
+		{name: "stale version", ifmatch: []string{common.VersionTag(1)}, want: common.ErrPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRevisionsRepo()
+			s := newTestService(repo)
+
+			_, err := s.ChangeArticleStatus(&ChangeArticleStatusRequest{ID: 1, Actor: author, Status: types.ArticleArchived, IfMatch: tt.ifmatch})
+			if err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if stored, _ := repo.GetOneArticle(1); (stored.Status == types.ArticleArchived) != (tt.want == nil) {
+				t.Fatalf("unexpected stored article %+v", stored)
+			}
+		})
+	}
+}
+
+func TestToggleLikeOfHiddenArticle(t *testing.T) {
+	reader := policy.Actor{UserID: 2, Permissions: types.DefaultRolePermissions[types.RoleCommon]}
+	tests := []struct {
+		name   string
+		status types.ArticleStatus
+		like   types.Like
+		want   error
+	}{
+		{name: "published article", status: types.ArticlePublished, like: types.Like{ArticleID: 1}},
+		{name: "comment of published article", status: types.ArticlePublished, like: types.Like{CommentID: 1}},
+		{name: "draft", status: types.ArticleDraft, like: types.Like{ArticleID: 1}, want: common.ErrNotFound},
+		{name: "comment of draft", status: types.ArticleDraft, like: types.Like{CommentID: 1}, want: common.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRevisionsRepo()
+			repo.articles[1].Status = tt.status
+			repo.comments = map[uint]*types.Comment{1: {ID: 1, RawText: "text", Version: 1, AuthorID: authorID, ArticleID: 1}}
+			s := newTestService(repo)
+
+			like := tt.like
+			like.UserID = reader.UserID
+			if err := s.ToggleLike(&like, true, reader); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if (len(repo.likes) == 1) != (tt.want == nil) {
+				t.Fatalf("unexpected likes %+v", repo.likes)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package types
+
+import "time"
+
+// AuditEntry records an action which was performed on somebody else's
+// resource using an explicit override
+type AuditEntry struct {
+	ID uint `gorm:"primaryKey"`
+
+	ActorID   uint      `gorm:"not null;index" json:"actorid"`
+	OwnerID   uint      `gorm:"not null;index" json:"ownerid"`
+	Action    string    `gorm:"not null" json:"action"`
+	TargetID  uint      `gorm:"not null" json:"targetid"`
+	Reason    string    `gorm:"not null" json:"reason"`
+	CreatedAt time.Time `gorm:"not null" json:"created"`
+}
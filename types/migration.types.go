@@ -0,0 +1,9 @@
+package types
+
+import "time"
+
+// Migration records a one-time data migration which was applied on start
+type Migration struct {
+	Name      string    `gorm:"primaryKey"`
+	AppliedAt time.Time `gorm:"not null"`
+}
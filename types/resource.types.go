@@ -1,23 +1,73 @@
 package types
 
+import "time"
+
+// ArticleStatus is a state of article workflow, only published articles
+// are visible to everyone
+type ArticleStatus string
+
+const (
+	ArticleDraft     ArticleStatus = "draft"
+	ArticleInReview  ArticleStatus = "review"
+	ArticlePublished ArticleStatus = "published"
+	ArticleArchived  ArticleStatus = "archived"
+)
+
 type Article struct {
-	ID        uint   `gorm:"primaryKey"`
-	Header    string `json:"header"`
-	Topic     string `json:"topic"`
-	ShortText string `json:"short"`
-	LongText  string `json:"long"`
+	ID        uint       `gorm:"primaryKey"`
+	Header    string     `json:"header" validate:"required,max=200"`
+	ShortText string     `json:"short" validate:"max=1000"`
+	LongText  string     `json:"long" validate:"required,max=100000"`
+	EditedAt  *time.Time `gorm:"default:null" json:"editedat,omitempty"`
+	Version   uint       `gorm:"not null;default:1" json:"version"` // incremented on every update, it is the article's ETag
+
+	// Articles created before statuses existed stay published, new ones start as draft
+	Status      ArticleStatus `gorm:"not null;default:published;index" json:"status"`
+	PublishAt   *time.Time    `gorm:"default:null;index" json:"publishat,omitempty"` // scheduled publication
+	PublishedAt *time.Time    `gorm:"default:null" json:"publishedat,omitempty"`
 
 	AuthorID uint      `gorm:"not null" json:"authorid"`
+	Tags     []Tag     `gorm:"many2many:article_tags;constraint:OnDelete:CASCADE" json:"tags" validate:"max=10"` // only slugs of tags are read from requests
 	Comment  []Comment `gorm:"constraint:OnDelete:CASCADE;foreignKey:ArticleID" json:"-"`
 	Like     []Like    `gorm:"constraint:OnDelete:CASCADE;foreignKey:ArticleID" json:"-"`
 }
 
+// Tag groups articles by subject, slug is its normalized unique name
+type Tag struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Slug string `gorm:"not null;uniqueIndex" json:"slug" validate:"max=100"`
+	Name string `gorm:"not null" json:"name" validate:"required,max=100"`
+}
+
+// TagCount is tag with number of published articles which have it
+type TagCount struct {
+	Tag
+	Count int64 `json:"count"`
+}
+
+// ArticleRevision is immutable content of the article after one change,
+// revisions of every article are numbered from 1
+type ArticleRevision struct {
+	ID           uint      `gorm:"primaryKey" json:"-"`
+	ArticleID    uint      `gorm:"not null;uniqueIndex:idx_article_revision" json:"articleid"`
+	Article      Article   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Number       uint      `gorm:"not null;uniqueIndex:idx_article_revision" json:"number"`
+	Header       string    `json:"header"`
+	ShortText    string    `json:"short,omitempty"`
+	LongText     string    `json:"long,omitempty"`
+	AuthorID     uint      `gorm:"not null" json:"authorid"` // who made the change
+	CreatedAt    time.Time `gorm:"not null" json:"createdat"`
+	RestoredFrom *uint     `gorm:"default:null" json:"restoredfrom,omitempty"` // number of restored revision
+}
+
 type Comment struct {
-	ID      uint   `gorm:"primaryKey"`
-	RawText string `json:"text"`
+	ID       uint       `gorm:"primaryKey"`
+	RawText  string     `json:"text" validate:"required,max=5000"`
+	EditedAt *time.Time `gorm:"default:null" json:"editedat,omitempty"`
+	Version  uint       `gorm:"not null;default:1" json:"version"` // incremented on every update, it is the comment's ETag
 
 	AuthorID       uint     `gorm:"not null" json:"authorid"`
-	ArticleID      uint     `gorm:"not null" json:"articleid"`
+	ArticleID      uint     `gorm:"not null" json:"articleid" validate:"required"`
 	ReplyCommentID *uint    `gorm:"default:null" json:"replyid"`
 	ReplyComment   *Comment `gorm:"constraint:OnDelete:CASCADE" json:"-"`
 	Like           []Like   `gorm:"constraint:OnDelete:CASCADE;foreignKey:CommentID" json:"-"`
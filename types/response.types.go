@@ -1,17 +1,18 @@
 package types
 
-type Response struct {
-	Error   []ErrorDetail
-	Status  uint
-	Message string
+// Problem is RFC 7807 problem details object, every error response has this shape
+type Problem struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Status    int           `json:"status"`
+	Detail    string        `json:"detail"`
+	Code      string        `json:"code"`
+	RequestID string        `json:"requestid,omitempty"`
+	Errors    []ErrorDetail `json:"errors,omitempty"`
 }
 
 type ErrorDetail struct {
-	ErrorType    string
-	ErrorMessage string
-}
-
-type SimpleError struct {
-	Error string `json:"error"`
-	Code  int    `json:"code"`
+	ErrorType    string `json:"type"`
+	ErrorMessage string `json:"message"`
+	Field        string `json:"field,omitempty"` // invalid field of the request
 }
@@ -1,17 +1,93 @@
 package types
 
-type Roles uint
+type Permission string
 
 const (
-	RoleGuest Roles = iota
-	RoleCommon
-	RoleModerator
-	RoleAdmin
+	PermArticleRead      Permission = "article:read"
+	PermArticleCreate    Permission = "article:create"
+	PermArticleUpdateOwn Permission = "article:update:own" // edit, restore and change status of own articles
+	PermArticleUpdateAny Permission = "article:update:any"
+	PermArticleDeleteOwn Permission = "article:delete:own"
+	PermArticleDeleteAny Permission = "article:delete:any"
+	PermArticlePublish   Permission = "article:publish" // moderate articles, see drafts of others
+
+	PermCommentRead      Permission = "comment:read"
+	PermCommentCreate    Permission = "comment:create"
+	PermCommentUpdateOwn Permission = "comment:update:own"
+	PermCommentUpdateAny Permission = "comment:update:any"
+	PermCommentDeleteOwn Permission = "comment:delete:own"
+	PermCommentDeleteAny Permission = "comment:delete:any"
+
+	PermLikeCreate Permission = "like:create"
+
+	PermTagManage Permission = "tag:manage"
+
+	PermUserRead   Permission = "user:read"
+	PermUserManage Permission = "user:manage"
+)
+
+// VerifiedEmailPermissions are taken away from users with not verified
+// email if verification is required
+var VerifiedEmailPermissions = []Permission{
+	PermArticleCreate,
+	PermArticleUpdateOwn,
+	PermCommentCreate,
+	PermCommentUpdateOwn,
+}
+
+// Names of roles which are created on startup, see DefaultRolePermissions
+const (
+	RoleCommon    = "common"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
 )
 
+var commonPermissions = []Permission{
+	PermArticleRead,
+	PermArticleCreate,
+	PermArticleUpdateOwn,
+	PermArticleDeleteOwn,
+	PermCommentRead,
+	PermCommentCreate,
+	PermCommentUpdateOwn,
+	PermCommentDeleteOwn,
+	PermLikeCreate,
+	PermUserRead,
+}
+
+var moderatorPermissions = append([]Permission{
+	PermArticleUpdateAny,
+	PermArticleDeleteAny,
+	PermArticlePublish,
+	PermTagManage,
+	PermCommentUpdateAny,
+	PermCommentDeleteAny,
+}, commonPermissions...)
+
+var adminPermissions = append([]Permission{
+	PermUserManage,
+}, moderatorPermissions...)
+
+// DefaultRolePermissions is a permission set of every default role.
+// Missing permissions are added to roles on startup
+var DefaultRolePermissions = map[string][]Permission{
+	RoleCommon:    commonPermissions,
+	RoleModerator: moderatorPermissions,
+	RoleAdmin:     adminPermissions,
+}
+
 type RoleData struct {
-	ID       uint `gorm:"primaryKey"`
-	RoleName string
+	ID          uint             `gorm:"primaryKey"`
+	RoleName    string           `gorm:"index"`
+	RequireMFA  bool             `gorm:"not null;default:false"` // permissions of the role are given only to users with TOTP
+	Permissions []RolePermission `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type RolePermission struct {
+	ID uint `gorm:"primaryKey"`
+
+	RoleDataID uint       `gorm:"not null;uniqueIndex:idx_role_permission"`
+	Permission Permission `gorm:"not null;uniqueIndex:idx_role_permission"`
 }
 
 type Role struct {
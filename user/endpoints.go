@@ -4,17 +4,43 @@ import (
 	"context"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/maxik12233/blog/types"
 )
 
 type UserEndpoints struct {
 	CreateUser             endpoint.Endpoint
 	DeleteUser             endpoint.Endpoint
 	LoginUser              endpoint.Endpoint
+	RefreshToken           endpoint.Endpoint
+	Logout                 endpoint.Endpoint
+	LogoutAll              endpoint.Endpoint
 	GetAllUsers            endpoint.Endpoint
 	GetOneUser             endpoint.Endpoint
 	UpdateUserContactInfo  endpoint.Endpoint
 	UpdateUserPersonalInfo endpoint.Endpoint
 	UpdateLocation         endpoint.Endpoint
+	GetRoles               endpoint.Endpoint
+	GrantRole              endpoint.Endpoint
+	RevokeRole             endpoint.Endpoint
+	GetUsersByRole         endpoint.Endpoint
+	ForgotPassword         endpoint.Endpoint
+	ResetPassword          endpoint.Endpoint
+	VerifyEmail            endpoint.Endpoint
+	ResendVerification     endpoint.Endpoint
+	VerifyMFA              endpoint.Endpoint
+	EnrollTOTP             endpoint.Endpoint
+	ConfirmTOTP            endpoint.Endpoint
+	DisableTOTP            endpoint.Endpoint
+	SetRoleRequireMFA      endpoint.Endpoint
+	OIDCLogin              endpoint.Endpoint
+	OIDCCallback           endpoint.Endpoint
+	CreateAPIToken         endpoint.Endpoint
+	GetAPITokens           endpoint.Endpoint
+	RevokeAPIToken         endpoint.Endpoint
+	GetSessions            endpoint.Endpoint
+	RevokeSession          endpoint.Endpoint
+	RevokeOtherSessions    endpoint.Endpoint
+	ChangePassword         endpoint.Endpoint
 }
 
 func MakeUserEndpoints(s UserService) UserEndpoints {
@@ -22,44 +48,69 @@ func MakeUserEndpoints(s UserService) UserEndpoints {
 		CreateUser:             makeCreateUserEndpoint(s),
 		DeleteUser:             makeDeleteUserEndpoint(s),
 		LoginUser:              makeLoginUserEndpoint(s),
+		RefreshToken:           makeRefreshTokenEndpoint(s),
+		Logout:                 makeLogoutEndpoint(s),
+		LogoutAll:              makeLogoutAllEndpoint(s),
 		GetAllUsers:            makeGetAllUsersEndpoint(s),
 		GetOneUser:             makeGetOneUserEndpoint(s),
 		UpdateUserContactInfo:  makeUpdateUserContactInfoEndpoint(s),
 		UpdateUserPersonalInfo: makeUpdateUserPersonalInfoEndpoint(s),
 		UpdateLocation:         makeUpdateLocationEndpoint(s),
+		GetRoles:               makeGetRolesEndpoint(s),
+		GrantRole:              makeGrantRoleEndpoint(s),
+		RevokeRole:             makeRevokeRoleEndpoint(s),
+		GetUsersByRole:         makeGetUsersByRoleEndpoint(s),
+		ForgotPassword:         makeForgotPasswordEndpoint(s),
+		ResetPassword:          makeResetPasswordEndpoint(s),
+		VerifyEmail:            makeVerifyEmailEndpoint(s),
+		ResendVerification:     makeResendVerificationEndpoint(s),
+		VerifyMFA:              makeVerifyMFAEndpoint(s),
+		EnrollTOTP:             makeEnrollTOTPEndpoint(s),
+		ConfirmTOTP:            makeConfirmTOTPEndpoint(s),
+		DisableTOTP:            makeDisableTOTPEndpoint(s),
+		SetRoleRequireMFA:      makeSetRoleRequireMFAEndpoint(s),
+		OIDCLogin:              makeOIDCLoginEndpoint(s),
+		OIDCCallback:           makeOIDCCallbackEndpoint(s),
+		CreateAPIToken:         makeCreateAPITokenEndpoint(s),
+		GetAPITokens:           makeGetAPITokensEndpoint(s),
+		RevokeAPIToken:         makeRevokeAPITokenEndpoint(s),
+		GetSessions:            makeGetSessionsEndpoint(s),
+		RevokeSession:          makeRevokeSessionEndpoint(s),
+		RevokeOtherSessions:    makeRevokeOtherSessionsEndpoint(s),
+		ChangePassword:         makeChangePasswordEndpoint(s),
 	}
 }
 
 func makeUpdateLocationEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UpdateLocationRequest)
-		err := s.UpdateLocation(&req)
+		tag, err := s.UpdateLocation(&req)
 		if err != nil {
 			return nil, err
 		}
-		return "Location info updated", nil
+		return UpdateResponse{Message: "Location info updated", Tag: tag}, nil
 	}
 }
 
 func makeUpdateUserPersonalInfoEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UpdateUserPersonalInfoRequest)
-		err := s.UpdatePersonalInfo(&req)
+		tag, err := s.UpdatePersonalInfo(&req)
 		if err != nil {
 			return nil, err
 		}
-		return "Personal info updated", nil
+		return UpdateResponse{Message: "Personal info updated", Tag: tag}, nil
 	}
 }
 
 func makeUpdateUserContactInfoEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UpdateUserContactInfoRequest)
-		err := s.UpdateContactInfo(&req)
+		tag, err := s.UpdateContactInfo(&req)
 		if err != nil {
 			return nil, err
 		}
-		return "Contact info updated", nil
+		return UpdateResponse{Message: "Contact info updated", Tag: tag}, nil
 	}
 }
 
@@ -81,40 +132,32 @@ func makeGetOneUserEndpoint(s UserService) endpoint.Endpoint {
 		if err != nil {
 			return nil, err
 		}
-		return GetUserResponse{
-			ID:           user.ID,
-			Login:        user.Login,
-			PersonalInfo: *user.PersonalInfo,
-			ContactInfo:  *user.ContactInfo,
-		}, nil
+		return makeUserResponse(user), nil
 	}
 }
 
 func makeGetAllUsersEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		users, err := s.GetAll()
+		req := request.(GetAllUsersRequest)
+		users, total, next, err := s.GetAll(&req)
 		if err != nil {
 			return nil, err
 		}
-		var usersresp []GetUserResponse
-		for _, val := range users {
-			usersresp = append(usersresp, GetUserResponse{
-				ID:           val.ID,
-				Login:        val.Login,
-				PersonalInfo: *val.PersonalInfo,
-				ContactInfo:  *val.ContactInfo,
-			})
+		resp := GetAllUsersResponse{
+			Users: makeUsersResponse(users),
+			Total: total,
 		}
-		return GetAllUsersResponse{
-			Users: usersresp,
-		}, nil
+		if next != nil {
+			resp.NextCursor = next.String()
+		}
+		return resp, nil
 	}
 }
 
 func makeDeleteUserEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(DeleteUserRequest)
-		err := s.DeleteUser(req.ID)
+		err := s.DeleteUser(&req)
 		if err != nil {
 			return nil, err
 		}
@@ -125,12 +168,337 @@ func makeDeleteUserEndpoint(s UserService) endpoint.Endpoint {
 func makeLoginUserEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(LoginUserRequest)
-		token, err := s.LoginUser(req.Login, req.Password)
+		resp, err := s.LoginUser(&req)
+		if err != nil {
+			return nil, err
+		}
+		resp.NoCookie = req.NoCookie
+		return *resp, nil
+	}
+}
+
+func makeRefreshTokenEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RefreshTokenRequest)
+		token, refresh, err := s.RefreshToken(&req)
 		if err != nil {
 			return nil, err
 		}
 		return LoginUserResponse{
-			Token: token,
+			Token:        token,
+			RefreshToken: refresh,
+			NoCookie:     req.NoCookie,
 		}, nil
 	}
 }
+
+func makeLogoutEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RefreshTokenRequest)
+		err := s.Logout(req.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+		return "Logged out", nil
+	}
+}
+
+func makeLogoutAllEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(LogoutAllRequest)
+		err := s.RevokeAllTokens(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return "Logged out from all sessions", nil
+	}
+}
+
+func makeGetRolesEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		roles, err := s.GetRoles()
+		if err != nil {
+			return nil, err
+		}
+		rolesresp := make([]RoleResponse, 0, len(roles))
+		for _, val := range roles {
+			perms := make([]types.Permission, 0, len(val.Permissions))
+			for _, perm := range val.Permissions {
+				perms = append(perms, perm.Permission)
+			}
+			rolesresp = append(rolesresp, RoleResponse{
+				ID:          val.ID,
+				Name:        val.RoleName,
+				RequireMFA:  val.RequireMFA,
+				Permissions: perms,
+			})
+		}
+		return GetRolesResponse{
+			Roles: rolesresp,
+		}, nil
+	}
+}
+
+func makeGrantRoleEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UserRoleRequest)
+		err := s.GrantRole(req.ID, req.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		return "Role granted", nil
+	}
+}
+
+func makeRevokeRoleEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(UserRoleRequest)
+		err := s.RevokeRole(req.ID, req.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		return "Role revoked", nil
+	}
+}
+
+func makeGetUsersByRoleEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(GetUsersByRoleRequest)
+		users, err := s.GetUsersByRole(req.RoleID)
+		if err != nil {
+			return nil, err
+		}
+		return GetAllUsersResponse{
+			Users: makeUsersResponse(users),
+		}, nil
+	}
+}
+
+func makeForgotPasswordEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ForgotPasswordRequest)
+		err := s.ForgotPassword(&req)
+		if err != nil {
+			return nil, err
+		}
+		return "If account with this email exists, reset token was sent to it", nil
+	}
+}
+
+func makeResetPasswordEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResetPasswordRequest)
+		err := s.ResetPassword(&req)
+		if err != nil {
+			return nil, err
+		}
+		return "Password changed", nil
+	}
+}
+
+func makeVerifyEmailEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(VerifyEmailRequest)
+		err := s.VerifyEmail(req.Token)
+		if err != nil {
+			return nil, err
+		}
+		return "Email verified", nil
+	}
+}
+
+func makeResendVerificationEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResendVerificationRequest)
+		err := s.ResendVerification(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return "Verification email sent", nil
+	}
+}
+
+func makeVerifyMFAEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(VerifyMFARequest)
+		resp, err := s.VerifyMFA(&req)
+		if err != nil {
+			return nil, err
+		}
+		resp.NoCookie = req.NoCookie
+		return *resp, nil
+	}
+}
+
+func makeEnrollTOTPEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TOTPRequest)
+		secret, uri, err := s.EnrollTOTP(req.ID)
+		if err != nil {
+			return nil, err
+		}
+		return EnrollTOTPResponse{Secret: secret, URI: uri}, nil
+	}
+}
+
+func makeConfirmTOTPEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TOTPRequest)
+		codes, err := s.ConfirmTOTP(req.ID, req.Code)
+		if err != nil {
+			return nil, err
+		}
+		return ConfirmTOTPResponse{RecoveryCodes: codes}, nil
+	}
+}
+
+func makeDisableTOTPEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TOTPRequest)
+		err := s.DisableTOTP(req.ID, req.Code, req.RecoveryCode)
+		if err != nil {
+			return nil, err
+		}
+		return "Two-factor authentication disabled", nil
+	}
+}
+
+func makeSetRoleRequireMFAEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SetRoleRequireMFARequest)
+		err := s.SetRoleRequireMFA(req.RoleID, req.Required)
+		if err != nil {
+			return nil, err
+		}
+		return "Role updated", nil
+	}
+}
+
+func makeOIDCLoginEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(OIDCLoginRequest)
+		url, state, err := s.OIDCLogin(req.Provider)
+		if err != nil {
+			return nil, err
+		}
+		return OIDCLoginResponse{URL: url, State: state}, nil
+	}
+}
+
+func makeOIDCCallbackEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(OIDCCallbackRequest)
+		resp, err := s.OIDCCallback(&req)
+		if err != nil {
+			return nil, err
+		}
+		return *resp, nil
+	}
+}
+
+func makeCreateAPITokenEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(CreateAPITokenRequest)
+		token, apitoken, err := s.CreateAPIToken(&req)
+		if err != nil {
+			return nil, err
+		}
+		return CreateAPITokenResponse{Token: token, APIToken: apitoken}, nil
+	}
+}
+
+func makeGetAPITokensEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(APITokensRequest)
+		tokens, err := s.GetAPITokens(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return GetAPITokensResponse{Tokens: tokens}, nil
+	}
+}
+
+func makeRevokeAPITokenEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(APITokensRequest)
+		err := s.RevokeAPIToken(req.UserID, req.TokenID)
+		if err != nil {
+			return nil, err
+		}
+		return "Token revoked", nil
+	}
+}
+
+func makeGetSessionsEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SessionsRequest)
+		sessions, err := s.GetSessions(req.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := GetSessionsResponse{Sessions: make([]SessionResponse, 0, len(sessions))}
+		for _, val := range sessions {
+			resp.Sessions = append(resp.Sessions, SessionResponse{
+				ID:         val.FamilyID,
+				UserAgent:  val.UserAgent,
+				IP:         val.IP,
+				CreatedAt:  val.StartedAt,
+				LastSeenAt: val.LastSeenAt,
+				Current:    val.FamilyID == req.CurrentSID,
+			})
+		}
+		return resp, nil
+	}
+}
+
+func makeRevokeSessionEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SessionsRequest)
+		err := s.RevokeSession(req.UserID, req.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		return "Session revoked", nil
+	}
+}
+
+func makeRevokeOtherSessionsEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(SessionsRequest)
+		err := s.RevokeOtherSessions(req.UserID, req.CurrentSID)
+		if err != nil {
+			return nil, err
+		}
+		return "Sessions revoked", nil
+	}
+}
+
+func makeChangePasswordEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChangePasswordRequest)
+		err := s.ChangePassword(&req)
+		if err != nil {
+			return nil, err
+		}
+		return "Password changed", nil
+	}
+}
+
+func makeUserResponse(user *User) GetUserResponse {
+	return GetUserResponse{
+		ID:           user.ID,
+		Login:        user.Login,
+		CreatedAt:    user.CreatedAt,
+		PersonalInfo: *user.PersonalInfo,
+		ContactInfo:  *user.ContactInfo,
+	}
+}
+
+func makeUsersResponse(users []*User) []GetUserResponse {
+	usersresp := make([]GetUserResponse, 0, len(users))
+	for _, val := range users {
+		usersresp = append(usersresp, makeUserResponse(val))
+	}
+	return usersresp
+}
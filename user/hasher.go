@@ -0,0 +1,190 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* hasher.go file stores password hashing algorithms. Hashes are stored
+   in self-describing format ($2a$<cost>$... for bcrypt and PHC string
+   $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key> for
+   argon2id), so hashes of old algorithms can still be verified and
+   replaced with hash of the current one on login */
+
+var ErrUnknownHash = errors.New("Unknown password hash format")
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify returns false for wrong password and error for malformed hash
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash tells if hash was made with other algorithm or parameters
+	NeedsRehash(hash string) bool
+}
+
+// NewPasswordHasher returns hasher which creates hashes with algorithm
+// (bcrypt or argon2id) and verifies hashes of both algorithms
+func NewPasswordHasher(algorithm string, bcryptCost int, argon Argon2idHasher) (PasswordHasher, error) {
+	bcryptHasher := &BcryptHasher{Cost: bcryptCost}
+	argonHasher := &argon
+
+	var current PasswordHasher
+	switch algorithm {
+	case "", "bcrypt":
+		current = bcryptHasher
+	case "argon2id":
+		current = argonHasher
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %s", algorithm)
+	}
+
+	return &multiHasher{
+		current: current,
+		bcrypt:  bcryptHasher,
+		argon2:  argonHasher,
+	}, nil
+}
+
+type multiHasher struct {
+	current PasswordHasher
+	bcrypt  *BcryptHasher
+	argon2  *Argon2idHasher
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h *multiHasher) Verify(hash string, password string) (bool, error) {
+	hasher := h.hasherFor(hash)
+	if hasher == nil {
+		return false, ErrUnknownHash
+	}
+	return hasher.Verify(hash, password)
+}
+
+func (h *multiHasher) NeedsRehash(hash string) bool {
+	if h.hasherFor(hash) != h.current {
+		return true
+	}
+	return h.current.NeedsRehash(hash)
+}
+
+func (h *multiHasher) hasherFor(hash string) PasswordHasher {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return h.bcrypt
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return h.argon2
+	}
+	return nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+type Argon2idHasher struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory || params.time != h.Time || params.threads != h.Threads ||
+		uint32(len(params.salt)) != h.SaltLen || uint32(len(params.key)) != h.KeyLen
+}
+
+func parseArgon2id(hash string) (*argon2Params, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnknownHash
+	}
+
+	var params argon2Params
+	// argon2 panics on zero time or threads instead of returning error
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil ||
+		params.time == 0 || params.threads == 0 {
+		return nil, ErrUnknownHash
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, ErrUnknownHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return nil, ErrUnknownHash
+	}
+	return &params, nil
+}
@@ -0,0 +1,213 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := testArgon2id
+	hash, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string %s", hash)
+	}
+
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatalf("parse own hash: %v", err)
+	}
+	if params.memory != h.Memory || params.time != h.Time || params.threads != h.Threads ||
+		uint32(len(params.salt)) != h.SaltLen || uint32(len(params.key)) != h.KeyLen {
+		t.Fatalf("parsed params %+v differ from hasher %+v", params, h)
+	}
+
+	if ok, err := h.Verify(hash, "correct horse"); err != nil || !ok {
+		t.Fatalf("right password: got %v, %v", ok, err)
+	}
+	if ok, err := h.Verify(hash, "wrong horse"); err != nil || ok {
+		t.Fatalf("wrong password: got %v, %v", ok, err)
+	}
+
+	other, _ := h.Hash("correct horse")
+	if other == hash {
+		t.Fatal("hashes of the same password have the same salt")
+	}
+}
+
+func TestParseArgon2idRejectsMalformedHash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty", hash: ""},
+		{name: "bcrypt", hash: "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{name: "argon2i", hash: "$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5"},
+		{name: "missing part", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ"},
+		{name: "extra part", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5$x"},
+		{name: "wrong version", hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5"},
+		{name: "no version", hash: "$argon2id$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5$"},
+		{name: "malformed params", hash: "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5"},
+		{name: "zero time", hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5"},
+		{name: "zero threads", hash: "$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5a2V5"},
+		{name: "malformed salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5a2V5a2V5"},
+		{name: "malformed key", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$!!!"},
+		{name: "empty key", hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$"},
+	}
+
+	h := testArgon2id
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseArgon2id(tt.hash); err != ErrUnknownHash {
+				t.Fatalf("got %v, want ErrUnknownHash", err)
+			}
+			if ok, err := h.Verify(tt.hash, "password"); ok || err == nil {
+				t.Fatalf("malformed hash is verified: %v, %v", ok, err)
+			}
+			if !h.NeedsRehash(tt.hash) {
+				t.Fatal("malformed hash doesn't need rehash")
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(h *Argon2idHasher)
+		rehash bool
+	}{
+		{name: "same params", modify: func(h *Argon2idHasher) {}},
+		{name: "memory", modify: func(h *Argon2idHasher) { h.Memory *= 2 }, rehash: true},
+		{name: "time", modify: func(h *Argon2idHasher) { h.Time++ }, rehash: true},
+		{name: "threads", modify: func(h *Argon2idHasher) { h.Threads++ }, rehash: true},
+		{name: "salt length", modify: func(h *Argon2idHasher) { h.SaltLen = 32 }, rehash: true},
+		{name: "key length", modify: func(h *Argon2idHasher) { h.KeyLen = 64 }, rehash: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testArgon2id
+			tt.modify(&h)
+			if got := h.NeedsRehash(hash); got != tt.rehash {
+				t.Fatalf("got %v, want %v", got, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestPasswordHasherAcceptsBothAlgorithms(t *testing.T) {
+	bcryptHash := hashPassword(t, "password")
+	argonHash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		algorithm string
+		hash      string
+		rehash    bool
+	}{
+		{algorithm: "bcrypt", hash: bcryptHash},
+		{algorithm: "bcrypt", hash: argonHash, rehash: true},
+		{algorithm: "argon2id", hash: argonHash},
+		{algorithm: "argon2id", hash: bcryptHash, rehash: true},
+	}
+
+	for _, tt := range tests {
+		h, err := NewPasswordHasher(tt.algorithm, bcrypt.MinCost, testArgon2id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := h.Verify(tt.hash, "password"); err != nil || !ok {
+			t.Errorf("%s verifies %.10s: got %v, %v", tt.algorithm, tt.hash, ok, err)
+		}
+		if got := h.NeedsRehash(tt.hash); got != tt.rehash {
+			t.Errorf("%s needs rehash of %.10s: got %v, want %v", tt.algorithm, tt.hash, got, tt.rehash)
+		}
+	}
+
+	h, _ := NewPasswordHasher("bcrypt", bcrypt.MinCost+1, testArgon2id)
+	if !h.NeedsRehash(bcryptHash) {
+		t.Error("bcrypt hash with other cost doesn't need rehash")
+	}
+	if _, err := h.Verify("plain password", "password"); err != ErrUnknownHash {
+		t.Errorf("unknown hash: got %v, want ErrUnknownHash", err)
+	}
+	if _, err := NewPasswordHasher("md5", bcrypt.MinCost, testArgon2id); err == nil {
+		t.Error("unknown algorithm is accepted")
+	}
+}
+
+func TestLoginRehashesBcryptPassword(t *testing.T) {
+	repo := newMemRepo(&User{ID: 1, Login: "alice", Password: hashPassword(t, "password")})
+	s, _ := newTestService(t, repo)
+	hasher, err := NewPasswordHasher("argon2id", bcrypt.MinCost, testArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hasher = hasher
+
+	// Wrong password doesn't touch the hash
+	if _, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "wrong password"}); err == nil {
+		t.Fatal("login with wrong password succeeded")
+	}
+	user, _ := repo.GetOneById(1)
+	if !strings.HasPrefix(user.Password, "$2") {
+		t.Fatalf("hash is replaced after failed login: %s", user.Password)
+	}
+
+	if _, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"}); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	user, _ = repo.GetOneById(1)
+	if !strings.HasPrefix(user.Password, "$argon2id$") {
+		t.Fatalf("bcrypt hash is not replaced: %s", user.Password)
+	}
+	rehashed := user.Password
+
+	if _, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"}); err != nil {
+		t.Fatalf("login with rehashed password: %v", err)
+	}
+	user, _ = repo.GetOneById(1)
+	if user.Password != rehashed {
+		t.Fatal("current hash is replaced again")
+	}
+}
+
+// countingHasher counts verified passwords
+type countingHasher struct {
+	PasswordHasher
+	verified int
+}
+
+func (h *countingHasher) Verify(hash string, password string) (bool, error) {
+	h.verified++
+	return h.PasswordHasher.Verify(hash, password)
+}
+
+func TestLoginOfUnknownUserVerifiesPassword(t *testing.T) {
+	s, _ := newTestService(t, newMemRepo(&User{ID: 1, Login: "alice"}))
+	hasher := &countingHasher{PasswordHasher: s.hasher}
+	s.hasher = hasher
+
+	if _, err := s.LoginUser(&LoginUserRequest{Login: "bob", Password: "password"}); err == nil {
+		t.Fatal("login of unknown user succeeded")
+	}
+	// User without password signs in only with identity providers
+	if _, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"}); err == nil {
+		t.Fatal("login without password succeeded")
+	}
+	if hasher.verified != 2 {
+		t.Fatalf("got %d verified passwords, want 2", hasher.verified)
+	}
+}
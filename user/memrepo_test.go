@@ -0,0 +1,388 @@
+package user
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/types"
+)
+
+// memRepo keeps users in memory for service tests. Only methods which
+// tests call are implemented, others panic on the nil embedded interface
+type memRepo struct {
+	UserRepository
+
+	mu         sync.Mutex
+	users      map[uint]*User
+	resets     []*PasswordReset
+	sessions   []*Session
+	identities []*Identity
+	totps      map[uint]*TOTP // by user id
+	recovery   []*RecoveryCode
+	challenges []*MFAChallenge
+	requireMFA map[uint]bool // users with role which requires MFA
+}
+
+func newMemRepo(users ...*User) *memRepo {
+	r := &memRepo{users: map[uint]*User{}, totps: map[uint]*TOTP{}, requireMFA: map[uint]bool{}}
+	for _, user := range users {
+		if user.TokenVersion == 0 {
+			user.TokenVersion = 1
+		}
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *memRepo) GetOneById(id uint) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+	return r.withTOTP(user), nil
+}
+
+// withTOTP returns copy of user with TOTP preloaded like real repo does
+func (r *memRepo) withTOTP(user *User) *User {
+	copied := *user
+	if totp, ok := r.totps[user.ID]; ok {
+		totpCopy := *totp
+		copied.TOTP = &totpCopy
+	}
+	return &copied
+}
+
+func (r *memRepo) CreateUser(user *User) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, val := range r.users {
+		if val.Login == user.Login {
+			return 0, common.ErrAlreadyExists
+		}
+	}
+	user.ID = 1
+	for _, val := range r.users {
+		user.ID = max(user.ID, val.ID+1)
+	}
+	if user.TokenVersion == 0 {
+		user.TokenVersion = 1
+	}
+	for i := range user.Identity {
+		user.Identity[i].UserID = user.ID
+		copied := user.Identity[i]
+		r.identities = append(r.identities, &copied)
+	}
+	copied := *user
+	r.users[user.ID] = &copied
+	return user.ID, nil
+}
+
+func (r *memRepo) GetOneByLogin(login string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.Login == login {
+			return r.withTOTP(user), nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func (r *memRepo) GetRoleDataByName(name string) (*types.RoleData, error) {
+	if name != types.RoleCommon {
+		return nil, common.ErrNotFound
+	}
+	return &types.RoleData{ID: 1, RoleName: name}, nil
+}
+
+func (r *memRepo) GetIdentity(provider string, subject string) (*Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			copied := *identity
+			return &copied, nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func (r *memRepo) CreateIdentity(identity *Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *identity
+	r.identities = append(r.identities, &copied)
+	return nil
+}
+
+func (r *memRepo) GetVerifiedUsersByEmail(email string) ([]*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var users []*User
+	for _, user := range r.users {
+		if user.ContactInfo != nil && user.ContactInfo.EmailVerified && strings.EqualFold(user.ContactInfo.Email, email) {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	return users, nil
+}
+
+func (r *memRepo) UpdatePassword(userid uint, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userid]
+	if !ok {
+		return common.ErrNotFound
+	}
+	user.Password = hash
+	return nil
+}
+
+func (r *memRepo) CreatePasswordReset(reset *PasswordReset) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reset.ID = uint(len(r.resets) + 1)
+	reset.CreatedAt = time.Now()
+	copied := *reset
+	r.resets = append(r.resets, &copied)
+	return nil
+}
+
+func (r *memRepo) GetPasswordResetByTokenHash(hash string) (*PasswordReset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reset := range r.resets {
+		if reset.TokenHash == hash {
+			copied := *reset
+			return &copied, nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func (r *memRepo) UsePasswordReset(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reset := range r.resets {
+		if reset.ID == id {
+			if reset.UsedAt != nil {
+				return common.ErrInvalidToken
+			}
+			now := time.Now()
+			reset.UsedAt = &now
+			return nil
+		}
+	}
+	return common.ErrNotFound
+}
+
+func (r *memRepo) InvalidatePasswordResets(userid uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, reset := range r.resets {
+		if reset.UserID == userid && reset.UsedAt == nil {
+			reset.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) IncrementTokenVersion(userid uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userid]
+	if !ok {
+		return common.ErrNotFound
+	}
+	user.TokenVersion++
+	return nil
+}
+
+func (r *memRepo) RevokeUserSessions(userid uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, session := range r.sessions {
+		if session.UserID == userid && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *memRepo) RevokeUserAPITokens(userid uint) error {
+	return nil
+}
+
+func (r *memRepo) GetUserRoles(id uint) ([]string, error) {
+	return []string{types.RoleCommon}, nil
+}
+
+// GetUserPermissions gives role which requires MFA only to users with confirmed TOTP
+func (r *memRepo) GetUserPermissions(id uint) ([]types.Permission, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if totp, ok := r.totps[id]; r.requireMFA[id] && (!ok || !totp.Confirmed) {
+		return []types.Permission{}, nil
+	}
+	return types.DefaultRolePermissions[types.RoleCommon], nil
+}
+
+func (r *memRepo) GetTokenVersion(userid uint) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[userid]
+	if !ok {
+		return 0, common.ErrNotFound
+	}
+	return user.TokenVersion, nil
+}
+
+func (r *memRepo) CreateSession(session *Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session.ID = uint(len(r.sessions) + 1)
+	session.CreatedAt = time.Now()
+	copied := *session
+	r.sessions = append(r.sessions, &copied)
+	return nil
+}
+
+func (r *memRepo) GetTOTP(userid uint) (*TOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	totp, ok := r.totps[userid]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+	copied := *totp
+	return &copied, nil
+}
+
+func (r *memRepo) CreateTOTP(totp *TOTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	totp.ID = uint(len(r.totps) + 1)
+	for _, val := range r.totps {
+		totp.ID = max(totp.ID, val.ID+1)
+	}
+	totp.CreatedAt = time.Now()
+	copied := *totp
+	r.totps[totp.UserID] = &copied
+	return nil
+}
+
+func (r *memRepo) ConfirmTOTP(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, totp := range r.totps {
+		if totp.ID == id {
+			totp.Confirmed = true
+			return nil
+		}
+	}
+	return common.ErrNotFound
+}
+
+func (r *memRepo) ReplaceRecoveryCodes(userid uint, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	codes := make([]*RecoveryCode, 0, len(r.recovery)+len(hashes))
+	for _, code := range r.recovery {
+		if code.UserID != userid {
+			codes = append(codes, code)
+		}
+	}
+	for _, hash := range hashes {
+		codes = append(codes, &RecoveryCode{ID: uint(len(codes) + 1), UserID: userid, CodeHash: hash})
+	}
+	r.recovery = codes
+	return nil
+}
+
+func (r *memRepo) UseTOTPStep(id uint, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, totp := range r.totps {
+		if totp.ID == id {
+			if totp.LastUsedStep >= step {
+				return common.ErrInvalidCode
+			}
+			totp.LastUsedStep = step
+			return nil
+		}
+	}
+	return common.ErrNotFound
+}
+
+func (r *memRepo) UseRecoveryCode(userid uint, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, code := range r.recovery {
+		if code.UserID == userid && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return nil
+		}
+	}
+	return common.ErrInvalidCode
+}
+
+func (r *memRepo) CreateMFAChallenge(challenge *MFAChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	challenge.ID = uint(len(r.challenges) + 1)
+	challenge.CreatedAt = time.Now()
+	copied := *challenge
+	r.challenges = append(r.challenges, &copied)
+	return nil
+}
+
+func (r *memRepo) GetMFAChallengeByTokenHash(hash string) (*MFAChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, challenge := range r.challenges {
+		if challenge.TokenHash == hash {
+			copied := *challenge
+			return &copied, nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func (r *memRepo) IncrementMFAChallengeAttempts(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, challenge := range r.challenges {
+		if challenge.ID == id {
+			challenge.Attempts++
+			return nil
+		}
+	}
+	return common.ErrNotFound
+}
+
+func (r *memRepo) UseMFAChallenge(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, challenge := range r.challenges {
+		if challenge.ID == id && challenge.UsedAt == nil {
+			now := time.Now()
+			challenge.UsedAt = &now
+			return nil
+		}
+	}
+	return common.ErrUnauthorized
+}
+
+func (r *memRepo) IsMFARequired(userid uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requireMFA[userid], nil
+}
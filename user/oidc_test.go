@@ -0,0 +1,127 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/maxik12233/blog/oidc"
+)
+
+func oidcClaims(subject string, email string, verified bool) *oidc.Claims {
+	return &oidc.Claims{
+		Email:             email,
+		EmailVerified:     verified,
+		PreferredUsername: "alice",
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+func TestFindOrCreateOIDCUser(t *testing.T) {
+	verifiedAlice := func() *User {
+		return &User{ID: 7, Login: "alice", ContactInfo: &ContactInfo{Email: "alice@example.com", EmailVerified: true}}
+	}
+	unverifiedAlice := func() *User {
+		return &User{ID: 7, Login: "alice", ContactInfo: &ContactInfo{Email: "alice@example.com", EmailVerified: false}}
+	}
+
+	tests := []struct {
+		name      string
+		user      *User
+		claims    *oidc.Claims
+		linked    bool   // identity is linked to the existing user
+		email     string // email of the created user
+		loginPart string // login of the created user starts with it
+	}{
+		{
+			name:   "verified email on both sides links existing user",
+			user:   verifiedAlice(),
+			claims: oidcClaims("sub-1", "Alice@Example.com", true),
+			linked: true,
+		},
+		{
+			name:      "unverified email of the provider creates new user",
+			user:      verifiedAlice(),
+			claims:    oidcClaims("sub-1", "alice@example.com", false),
+			loginPart: "alice-",
+		},
+		{
+			name:      "unverified email of existing user creates new user",
+			user:      unverifiedAlice(),
+			claims:    oidcClaims("sub-1", "alice@example.com", true),
+			email:     "alice@example.com",
+			loginPart: "alice-",
+		},
+		{
+			name:      "unknown email creates new user",
+			claims:    oidcClaims("sub-1", "alice@example.com", true),
+			email:     "alice@example.com",
+			loginPart: "alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemRepo()
+			if tt.user != nil {
+				repo = newMemRepo(tt.user)
+			}
+			s, _ := newTestService(t, repo)
+
+			id, err := s.findOrCreateOIDCUser("stub", tt.claims)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			identity, err := repo.GetIdentity("stub", tt.claims.Subject)
+			if err != nil || identity.UserID != id {
+				t.Fatalf("identity is not linked to user %d: %+v, %v", id, identity, err)
+			}
+
+			if tt.linked {
+				if id != tt.user.ID {
+					t.Fatalf("got user %d, want existing user %d", id, tt.user.ID)
+				}
+				return
+			}
+			if tt.user != nil && id == tt.user.ID {
+				t.Fatalf("identity was linked to existing user")
+			}
+			user, _ := repo.GetOneById(id)
+			if !strings.HasPrefix(user.Login, tt.loginPart) || (tt.user != nil && user.Login == tt.user.Login) {
+				t.Fatalf("unexpected login %q of created user", user.Login)
+			}
+			if user.Password != "" {
+				t.Fatalf("created user has password")
+			}
+			if user.ContactInfo.Email != tt.email || user.ContactInfo.EmailVerified != (tt.email != "") {
+				t.Fatalf("unexpected contact info %+v of created user", user.ContactInfo)
+			}
+		})
+	}
+}
+
+func TestFindOrCreateOIDCUserReusesIdentity(t *testing.T) {
+	repo := newMemRepo()
+	s, _ := newTestService(t, repo)
+
+	first, err := s.findOrCreateOIDCUser("stub", oidcClaims("sub-1", "alice@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Email changed on the provider's side, but subject is the same
+	second, err := s.findOrCreateOIDCUser("stub", oidcClaims("sub-1", "other@example.com", true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("got user %d, want user %d of the identity", second, first)
+	}
+	other, err := s.findOrCreateOIDCUser("other", oidcClaims("sub-1", "", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Fatalf("subject of other provider was linked to the same user")
+	}
+}
@@ -0,0 +1,72 @@
+package user
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maxik12233/blog/common"
+)
+
+/* password.go file stores rules which every new password must follow */
+
+// PasswordPolicy checks length of new password and rejects passwords
+// from the breached list. List is a text file with one password per line
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// LoadPasswordPolicy reads breached passwords from path, empty path means
+// that only length is checked
+func LoadPasswordPolicy(path string) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength: common.PASSWORD_MIN_LENGTH,
+		MaxLength: common.PASSWORD_MAX_LENGTH,
+		breached:  make(map[string]struct{}),
+	}
+	if path == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *PasswordPolicy) Check(password string, login string) error {
+	length := utf8.RuneCountInString(password)
+	// bcrypt uses only first 72 bytes of the password
+	if length < p.MinLength || length > p.MaxLength || len(password) > 72 {
+		return common.ErrWeakPassword
+	}
+	lower := strings.ToLower(password)
+	if login != "" && lower == strings.ToLower(login) {
+		return common.ErrWeakPassword
+	}
+	if _, ok := p.breached[lower]; ok {
+		return common.ErrWeakPassword
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) BreachedCount() int {
+	return len(p.breached)
+}
@@ -1,6 +1,10 @@
 package user
 
 import (
+	"strings"
+	"time"
+
+	"github.com/maxik12233/blog/common"
 	"github.com/maxik12233/blog/types"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -12,11 +16,64 @@ type UserRepository interface {
 	DeleteUser(id uint) error
 	UpdateUserContactInfo(userid uint, contactinfo *ContactInfo) error
 	UpdateUserPersonalInfo(userid uint, personalinfo *PersonalInfo) error
-	UpdateLocation(userid uint, loc *Location) error
-	GetAll() ([]*User, error)
+	UpdateLocation(userid uint, loc *Location, version uint) error
+	GetAll(req *GetAllUsersRequest) ([]*User, int64, error)
 	GetOneById(id uint) (*User, error)
 	GetOneByLogin(login string) (*User, error)
-	GetUserRoles(id uint) ([]uint, error)
+	GetUserRoles(id uint) ([]string, error)
+	GetUserPermissions(id uint) ([]types.Permission, error)
+	GetRoleDataByName(name string) (*types.RoleData, error)
+	GetRoleDataById(id uint) (*types.RoleData, error)
+	GetAllRoleData() ([]*types.RoleData, error)
+	AddUserRole(userid uint, roledataid uint) error
+	RemoveUserRole(userid uint, roledataid uint) error
+	GetUsersByRole(roledataid uint) ([]*User, error)
+	GetVerifiedUsersByEmail(email string) ([]*User, error)
+	UpdatePassword(userid uint, hash string) error
+	CreatePasswordReset(reset *PasswordReset) error
+	GetPasswordResetByTokenHash(hash string) (*PasswordReset, error)
+	UsePasswordReset(id uint) error
+	InvalidatePasswordResets(userid uint) error
+	CreateVerification(verification *Verification) error
+	GetVerificationByTokenHash(hash string) (*Verification, error)
+	UseVerification(id uint) error
+	SetEmailVerified(userid uint, email string) error
+	IsEmailVerified(userid uint) (bool, error)
+	GetTOTP(userid uint) (*TOTP, error)
+	CreateTOTP(totp *TOTP) error
+	ConfirmTOTP(id uint) error
+	UseTOTPStep(id uint, step int64) error
+	DeleteTOTP(userid uint) error
+	ReplaceRecoveryCodes(userid uint, hashes []string) error
+	UseRecoveryCode(userid uint, hash string) error
+	CreateMFAChallenge(challenge *MFAChallenge) error
+	GetMFAChallengeByTokenHash(hash string) (*MFAChallenge, error)
+	IncrementMFAChallengeAttempts(id uint) error
+	UseMFAChallenge(id uint) error
+	IsMFARequired(userid uint) (bool, error)
+	SetRoleRequireMFA(roledataid uint, required bool) error
+	CreateOIDCState(state *OIDCState) error
+	GetOIDCStateByHash(hash string) (*OIDCState, error)
+	UseOIDCState(id uint) error
+	GetIdentity(provider string, subject string) (*Identity, error)
+	CreateIdentity(identity *Identity) error
+	CreateAPIToken(token *APIToken) error
+	GetAPITokens(userid uint) ([]*APIToken, error)
+	GetAPITokenByHash(hash string) (*APIToken, error)
+	TouchAPIToken(id uint) error
+	RevokeAPIToken(userid uint, id uint) error
+	RevokeUserAPITokens(userid uint) error
+	CreateSession(session *Session) error
+	GetSessionByTokenHash(hash string) (*Session, error)
+	RotateSession(old *Session, new *Session) error
+	RevokeSessionFamily(familyid string) error
+	RevokeUserSessions(userid uint) error
+	GetActiveSessions(userid uint) ([]*Session, error)
+	RevokeUserSessionFamily(userid uint, familyid string) error
+	RevokeUserSessionsExcept(userid uint, familyid string) error
+	GetTokenVersion(userid uint) (uint, error)
+	IncrementTokenVersion(userid uint) error
+	IsTokenRevoked(userid uint, version uint, sid string) (bool, error)
 }
 
 type UserRepo struct {
@@ -31,42 +88,57 @@ func NewUserRepo(db *gorm.DB, logger *zap.Logger) UserRepository {
 	}
 }
 
-func (repo *UserRepo) UpdateLocation(userid uint, loc *Location) error {
+// UpdateLocation replaces location of the user and increments version
+// of personal info, if it is still equal to version
+func (repo *UserRepo) UpdateLocation(userid uint, loc *Location, version uint) error {
 	repo.logger.Info("In UpdateLocation")
 
 	var personalid int
 	result := repo.db.Table("users").Select("personal_info_id").Where("id = ?", userid).Limit(1).Scan(&personalid)
 	if result.Error != nil {
 		repo.logger.Error("Error while updating location", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Error("Error while updating location", zap.Error(result.Error))
-		return ErrInvalidId
+		return common.ErrInvalidId
 	}
 
 	var id int
 	result = repo.db.Table("personal_infos").Select("location_id").Where("id = ?", personalid).Limit(1).Scan(&id)
 	if result.Error != nil {
 		repo.logger.Error("Error while updating location", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Error("Error while updating location", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
 	}
 
 	loc.ID = uint(id)
 
-	result = repo.db.Save(&loc)
-	if result.Error != nil {
-		repo.logger.Error("Error while updating location", zap.Error(result.Error))
-		return ErrInternalError
-	}
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&PersonalInfo{}).Where("id = ? AND version = ?", personalid, version).
+			Update("version", gorm.Expr("version + 1"))
+		if result.Error != nil {
+			repo.logger.Error("Error while updating location", zap.Error(result.Error))
+			return common.ErrInternalError
+		}
+		if result.RowsAffected == 0 {
+			repo.logger.Info("Personal info was changed while updating location")
+			return common.ErrPreconditionFailed
+		}
 
-	return nil
+		if result := tx.Save(loc); result.Error != nil {
+			repo.logger.Error("Error while updating location", zap.Error(result.Error))
+			return common.ErrInternalError
+		}
+		return nil
+	})
 }
 
+// UpdateUserPersonalInfo replaces personal info of the user, if its
+// version is still equal to personalinfo.Version
 func (repo *UserRepo) UpdateUserPersonalInfo(userid uint, personalinfo *PersonalInfo) error {
 	repo.logger.Info("In UpdateUserPersonalInfo")
 
@@ -74,24 +146,34 @@ func (repo *UserRepo) UpdateUserPersonalInfo(userid uint, personalinfo *Personal
 	result := repo.db.Table("users").Select("personal_info_id").Where("id = ?", userid).Limit(1).Scan(&id)
 	if result.Error != nil {
 		repo.logger.Error("Error while updating user's personal info", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Error("Error while updating user's personal info", zap.Error(result.Error))
-		return ErrInvalidId
+		return common.ErrInvalidId
 	}
 
-	personalinfo.ID = uint(id)
-
-	result = repo.db.Omit("location_id").Save(&personalinfo)
+	result = repo.db.Model(&PersonalInfo{}).Where("id = ? AND version = ?", id, personalinfo.Version).Updates(map[string]interface{}{
+		"first_name":      personalinfo.FirstName,
+		"last_name":       personalinfo.LastName,
+		"personal_status": personalinfo.PersonalStatus,
+		"description":     personalinfo.Description,
+		"version":         gorm.Expr("version + 1"),
+	})
 	if result.Error != nil {
 		repo.logger.Error("Error while updating user's personal info", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Personal info was changed while updating it")
+		return common.ErrPreconditionFailed
 	}
 
 	return nil
 }
 
+// UpdateUserContactInfo replaces contact info of the user, if its
+// version is still equal to contactinfo.Version
 func (repo *UserRepo) UpdateUserContactInfo(userid uint, contactinfo *ContactInfo) error {
 	repo.logger.Info("In UpdateUserContactInfo")
 
@@ -99,19 +181,26 @@ func (repo *UserRepo) UpdateUserContactInfo(userid uint, contactinfo *ContactInf
 	result := repo.db.Table("users").Select("contact_info_id").Where("id = ?", userid).Limit(1).Scan(&id)
 	if result.Error != nil {
 		repo.logger.Error("Error while updating user's contact info", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Error("Error while updating user's conctact info", zap.Error(result.Error))
-		return ErrInvalidId
+		return common.ErrInvalidId
 	}
 
-	contactinfo.ID = uint(id)
-
-	result = repo.db.Save(&contactinfo)
+	result = repo.db.Model(&ContactInfo{}).Where("id = ? AND version = ?", id, contactinfo.Version).Updates(map[string]interface{}{
+		"mobile":         contactinfo.Mobile,
+		"email":          contactinfo.Email,
+		"email_verified": contactinfo.EmailVerified,
+		"version":        gorm.Expr("version + 1"),
+	})
 	if result.Error != nil {
 		repo.logger.Error("Error while updating user's contact info", zap.Error(result.Error))
-		return ErrInternalError
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Contact info was changed while updating it")
+		return common.ErrPreconditionFailed
 	}
 
 	return nil
@@ -122,52 +211,191 @@ func (repo *UserRepo) CreateUser(user *User) (uint, error) {
 
 	if result := repo.db.Unscoped().Create(user); result.Error != nil {
 		repo.logger.Error("Error while creating user", zap.Error(result.Error))
-		return 0, ErrInternalError
+		return 0, common.ErrInternalError
 	}
 	return user.ID, nil
 }
 
-func (repo *UserRepo) GetUserRoles(id uint) ([]uint, error) {
+func (repo *UserRepo) GetUserRoles(id uint) ([]string, error) {
 	repo.logger.Info("In GetUserRoles")
 
 	var roles []*types.Role
-	if result := repo.db.Where("user_id = ?", id).Find(&roles); result.Error != nil {
+	if result := repo.db.Where("user_id = ?", id).Preload("RoleData").Find(&roles); result.Error != nil {
 		repo.logger.Error("Error while fetching user roles from db", zap.Error(result.Error))
-		return nil, ErrInternalError
+		return nil, common.ErrInternalError
 	}
-	rolesids := make([]uint, 0)
+	rolenames := make([]string, 0)
 	for _, val := range roles {
-		rolesids = append(rolesids, val.RoleDataID)
+		rolenames = append(rolenames, val.RoleData.RoleName)
 	}
-	return rolesids, nil
+	return rolenames, nil
 }
 
-func (repo *UserRepo) DeleteUser(id uint) error {
-	repo.logger.Info("In DeleteUser")
+func (repo *UserRepo) GetUserPermissions(id uint) ([]types.Permission, error) {
+	repo.logger.Info("In GetUserPermissions")
 
-	var user *User
-	result := repo.db.Find(&user, id)
+	// Roles which require MFA give nothing until the user confirms TOTP
+	perms := make([]types.Permission, 0)
+	result := repo.db.Model(&types.RolePermission{}).
+		Distinct("role_permissions.permission").
+		Joins("JOIN roles ON roles.role_data_id = role_permissions.role_data_id").
+		Joins("JOIN role_data ON role_data.id = role_permissions.role_data_id").
+		Where("roles.user_id = ?", id).
+		Where("role_data.require_mfa = false OR EXISTS (?)", repo.db.Model(&TOTP{}).Select("1").Where("user_id = ? AND confirmed = true", id)).
+		Pluck("role_permissions.permission", &perms)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching user permissions from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	return perms, nil
+}
+
+func (repo *UserRepo) GetRoleDataByName(name string) (*types.RoleData, error) {
+	repo.logger.Info("In GetRoleDataByName")
+
+	var roledata *types.RoleData
+	result := repo.db.Where("role_name = ?", name).Order("id").Limit(1).Find(&roledata)
 	if result.Error != nil {
-		repo.logger.Error("Error while deleting user from db", zap.Error(result.Error))
-		return ErrInternalError
+		repo.logger.Error("Error while fetching role data by name from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
-		repo.logger.Info("Users not found by id while deleting user")
-		return ErrNotFound
+		repo.logger.Info("Role data not found by name")
+		return nil, common.ErrNotFound
+	}
+
+	return roledata, nil
+}
+
+func (repo *UserRepo) GetRoleDataById(id uint) (*types.RoleData, error) {
+	repo.logger.Info("In GetRoleDataById")
+
+	var roledata *types.RoleData
+	result := repo.db.Where("id = ?", id).Preload("Permissions").Limit(1).Find(&roledata)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching role data by id from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Role data not found by id")
+		return nil, common.ErrNotFound
+	}
+
+	return roledata, nil
+}
+
+func (repo *UserRepo) GetAllRoleData() ([]*types.RoleData, error) {
+	repo.logger.Info("In GetAllRoleData")
+
+	var roledata []*types.RoleData
+	result := repo.db.Preload("Permissions").Order("id").Find(&roledata)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching role data from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return roledata, nil
+}
+
+func (repo *UserRepo) AddUserRole(userid uint, roledataid uint) error {
+	repo.logger.Info("In AddUserRole")
+
+	var count int64
+	result := repo.db.Model(&types.Role{}).Where("user_id = ? AND role_data_id = ?", userid, roledataid).Count(&count)
+	if result.Error != nil {
+		repo.logger.Error("Error while checking user role", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if count > 0 {
+		repo.logger.Info("User already has this role")
+		return common.ErrAlreadyExists
+	}
+
+	result = repo.db.Create(&types.Role{UserID: userid, RoleDataID: roledataid})
+	if result.Error != nil {
+		repo.logger.Error("Error while adding user role", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+func (repo *UserRepo) RemoveUserRole(userid uint, roledataid uint) error {
+	repo.logger.Info("In RemoveUserRole")
+
+	result := repo.db.Where("user_id = ? AND role_data_id = ?", userid, roledataid).Delete(&types.Role{})
+	if result.Error != nil {
+		repo.logger.Error("Error while removing user role", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("User doesn't have this role")
+		return common.ErrNotFound
 	}
-	// Delete assosiations
-	// TODO: Do it so you dont need to rewrite it after another new assotiation appears
-	var personalinfo PersonalInfo
-	repo.db.Find(&personalinfo, user.PersonalInfoID)
-	repo.db.Unscoped().Where("ID = ?", personalinfo.LocationID).Delete(&Location{})
-	repo.db.Unscoped().Where("ID = ?", user.PersonalInfoID).Delete(&PersonalInfo{})
-	repo.db.Unscoped().Where("ID = ?", user.ContactInfoID).Delete(&ContactInfo{})
-	repo.db.Unscoped().Model(&user).Association("Role").Unscoped().Clear()
 
-	result = repo.db.Unscoped().Delete(&user)
+	return nil
+}
+
+func (repo *UserRepo) GetUsersByRole(roledataid uint) ([]*User, error) {
+	repo.logger.Info("In GetUsersByRole")
+
+	var users []*User
+	result := repo.db.Where("id IN (?)", repo.db.Model(&types.Role{}).Select("user_id").Where("role_data_id = ?", roledataid)).
+		Preload("PersonalInfo.Location").Preload(clause.Associations).Find(&users)
 	if result.Error != nil {
-		repo.logger.Error("Error while deleting user from db", zap.Error(result.Error))
-		return ErrInternalError
+		repo.logger.Error("Error while fetching users by role from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return users, nil
+}
+
+func (repo *UserRepo) DeleteUser(id uint) error {
+	repo.logger.Info("In DeleteUser")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var user *User
+		result := tx.Find(&user, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.ErrNotFound
+		}
+		var personalinfo PersonalInfo
+		if err := tx.Find(&personalinfo, user.PersonalInfoID).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&user).Association("Role").Unscoped().Clear(); err != nil {
+			return err
+		}
+
+		// Delete assosiations
+		// TODO: Do it so you dont need to rewrite it after another new assotiation appears
+		if err := tx.Unscoped().Where("ID = ?", personalinfo.LocationID).Delete(&Location{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("ID = ?", user.PersonalInfoID).Delete(&PersonalInfo{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("ID = ?", user.ContactInfoID).Delete(&ContactInfo{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Session{}, &PasswordReset{}, &Verification{}, &TOTP{}, &RecoveryCode{}, &MFAChallenge{}, &Identity{}, &APIToken{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err == common.ErrNotFound {
+		repo.logger.Info("Users not found by id while deleting user")
+		return err
+	}
+	if err != nil {
+		repo.logger.Error("Error while deleting user from db", zap.Error(err))
+		return common.ErrInternalError
 	}
 
 	return nil
@@ -180,11 +408,11 @@ func (repo *UserRepo) GetOneById(id uint) (*User, error) {
 	result := repo.db.Where("ID = ?", id).Preload("PersonalInfo.Location").Preload(clause.Associations).Find(&user)
 	if result.Error != nil {
 		repo.logger.Error("Error while fetching user by id from db", zap.Error(result.Error))
-		return nil, ErrInternalError
+		return nil, common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Info("Users not found by id")
-		return nil, ErrNotFound
+		return nil, common.ErrNotFound
 	}
 
 	return user, nil
@@ -197,30 +425,743 @@ func (repo *UserRepo) GetOneByLogin(login string) (*User, error) {
 	result := repo.db.Where("login = ?", login).Preload("PersonalInfo.Location").Preload(clause.Associations).Find(&user)
 	if result.Error != nil {
 		repo.logger.Error("Error while fetching user by login from db", zap.Error(result.Error))
-		return nil, ErrInternalError
+		return nil, common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
 		repo.logger.Info("Users not found by login")
-		return nil, ErrNotFound
+		return nil, common.ErrNotFound
 	}
 
 	return user, nil
 }
 
-func (repo *UserRepo) GetAll() ([]*User, error) {
+var userSortColumns = map[string]string{
+	"id":        "users.id",
+	"login":     "users.login",
+	"createdat": "users.created_at",
+}
+
+// GetAll returns users matching filters of req and their total count.
+// Page has up to req.Limit+1 users, so caller knows if there is next page
+func (repo *UserRepo) GetAll(req *GetAllUsersRequest) ([]*User, int64, error) {
 	repo.logger.Info("In GetAll")
 
-	var users []*User
+	query := repo.db.Model(&User{})
+	if req.Login != "" {
+		query = query.Where("lower(users.login) LIKE ?", escapeLike(strings.ToLower(req.Login))+"%")
+	}
+	if req.Role != "" {
+		query = query.Where("users.id IN (?)", repo.db.Model(&types.Role{}).Select("roles.user_id").
+			Joins("JOIN role_data ON role_data.id = roles.role_data_id").Where("role_data.role_name = ?", req.Role))
+	}
+	if req.Country != "" || req.City != "" {
+		query = query.Joins("LEFT JOIN personal_infos ON personal_infos.id = users.personal_info_id").
+			Joins("LEFT JOIN locations ON locations.id = personal_infos.location_id")
+		if req.Country != "" {
+			query = query.Where("lower(locations.country) = lower(?)", req.Country)
+		}
+		if req.City != "" {
+			query = query.Where("lower(locations.city) = lower(?)", req.City)
+		}
+	}
+	if req.CreatedFrom != nil {
+		query = query.Where("users.created_at >= ?", *req.CreatedFrom)
+	}
+	if req.CreatedTo != nil {
+		query = query.Where("users.created_at < ?", *req.CreatedTo)
+	}
+	// filters are shared by count and page queries
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		repo.logger.Error("Error while counting users", zap.Error(result.Error))
+		return nil, 0, common.ErrInternalError
+	}
 
-	result := repo.db.Preload("PersonalInfo.Location").Preload(clause.Associations).Find(&users)
+	field, desc := strings.CutPrefix(req.Sort, "-")
+	column := userSortColumns[field]
+	direction, op := "ASC", ">"
+	if desc {
+		direction, op = "DESC", "<"
+	}
+	if req.Cursor != nil {
+		switch field {
+		case "login":
+			query = query.Where("(users.login, users.id) "+op+" (?, ?)", req.Cursor.Login, req.Cursor.ID)
+		case "createdat":
+			query = query.Where("(users.created_at, users.id) "+op+" (?, ?)", req.Cursor.CreatedAt, req.Cursor.ID)
+		default:
+			query = query.Where("users.id "+op+" ?", req.Cursor.ID)
+		}
+	}
+	order := column + " " + direction
+	if column != "users.id" {
+		order += ", users.id " + direction
+	}
+
+	var users []*User
+	result := query.Preload("PersonalInfo.Location").Preload("ContactInfo").
+		Order(order).Limit(req.Limit + 1).Find(&users)
 	if result.Error != nil {
 		repo.logger.Error("Error while fetching users from db", zap.Error(result.Error))
-		return nil, ErrInternalError
+		return nil, 0, common.ErrInternalError
+	}
+
+	return users, total, nil
+}
+
+// escapeLike escapes wildcards of LIKE pattern
+func escapeLike(val string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(val)
+}
+
+func (repo *UserRepo) CreateSession(session *Session) error {
+	repo.logger.Info("In CreateSession")
+
+	if result := repo.db.Create(session); result.Error != nil {
+		repo.logger.Error("Error while creating session", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetSessionByTokenHash(hash string) (*Session, error) {
+	repo.logger.Info("In GetSessionByTokenHash")
+
+	var session *Session
+	result := repo.db.Where("token_hash = ?", hash).Limit(1).Find(&session)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching session by token hash from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
 	}
 	if result.RowsAffected == 0 {
-		repo.logger.Info("Users not found while fetching all users")
-		return nil, ErrNotFound
+		repo.logger.Info("Session not found by token hash")
+		return nil, common.ErrNotFound
+	}
+
+	return session, nil
+}
+
+// RotateSession revokes old session and creates new one in one transaction.
+// If old session was already revoked by concurrent request ErrUnauthorized is returned
+func (repo *UserRepo) RotateSession(old *Session, new *Session) error {
+	repo.logger.Info("In RotateSession")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", old.ID).Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.ErrUnauthorized
+		}
+		return tx.Create(new).Error
+	})
+	if err == common.ErrUnauthorized {
+		repo.logger.Info("Session was already rotated")
+		return err
+	}
+	if err != nil {
+		repo.logger.Error("Error while rotating session", zap.Error(err))
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+func (repo *UserRepo) RevokeSessionFamily(familyid string) error {
+	repo.logger.Info("In RevokeSessionFamily")
+
+	result := repo.db.Model(&Session{}).Where("family_id = ? AND revoked_at IS NULL", familyid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking session family", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) RevokeUserSessions(userid uint) error {
+	repo.logger.Info("In RevokeUserSessions")
+
+	result := repo.db.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking user sessions", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// GetActiveSessions returns the last not revoked session of every family
+func (repo *UserRepo) GetActiveSessions(userid uint) ([]*Session, error) {
+	repo.logger.Info("In GetActiveSessions")
+
+	sessions := make([]*Session, 0)
+	result := repo.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userid, time.Now()).Order("last_seen_at DESC").Find(&sessions)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching sessions from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return sessions, nil
+}
+
+func (repo *UserRepo) RevokeUserSessionFamily(userid uint, familyid string) error {
+	repo.logger.Info("In RevokeUserSessionFamily")
+
+	result := repo.db.Model(&Session{}).Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userid, familyid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking session family", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *UserRepo) RevokeUserSessionsExcept(userid uint, familyid string) error {
+	repo.logger.Info("In RevokeUserSessionsExcept")
+
+	result := repo.db.Model(&Session{}).Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userid, familyid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking sessions", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetTokenVersion(userid uint) (uint, error) {
+	var version uint
+	result := repo.db.Table("users").Select("token_version").Where("id = ?", userid).Limit(1).Scan(&version)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching token version", zap.Error(result.Error))
+		return 0, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return 0, common.ErrNotFound
+	}
+	return version, nil
+}
+
+func (repo *UserRepo) IncrementTokenVersion(userid uint) error {
+	repo.logger.Info("In IncrementTokenVersion")
+
+	result := repo.db.Model(&User{}).Where("id = ?", userid).Update("token_version", gorm.Expr("token_version + 1"))
+	if result.Error != nil {
+		repo.logger.Error("Error while incrementing token version", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+// IsTokenRevoked is called on every authorized request by LoggingMiddleware,
+// so it doesn't log anything on the happy path
+func (repo *UserRepo) IsTokenRevoked(userid uint, version uint, sid string) (bool, error) {
+	current, err := repo.GetTokenVersion(userid)
+	if err == common.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current != version {
+		return true, nil
+	}
+
+	if sid == "" {
+		return false, nil
+	}
+	var count int64
+	result := repo.db.Model(&Session{}).Where("family_id = ? AND revoked_at IS NULL", sid).Count(&count)
+	if result.Error != nil {
+		repo.logger.Error("Error while checking session family", zap.Error(result.Error))
+		return false, common.ErrInternalError
+	}
+	return count == 0, nil
+}
+
+// GetVerifiedUsersByEmail returns users who verified the email, not verified
+// email can be set by anybody with access to the account
+func (repo *UserRepo) GetVerifiedUsersByEmail(email string) ([]*User, error) {
+	repo.logger.Info("In GetVerifiedUsersByEmail")
+
+	var users []*User
+	result := repo.db.Joins("ContactInfo").Where("\"ContactInfo\".email = ? AND \"ContactInfo\".email_verified = true", email).Find(&users)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching users by email from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
 	}
 
 	return users, nil
 }
+
+func (repo *UserRepo) UpdatePassword(userid uint, hash string) error {
+	repo.logger.Info("In UpdatePassword")
+
+	result := repo.db.Model(&User{}).Where("id = ?", userid).Update("password", hash)
+	if result.Error != nil {
+		repo.logger.Error("Error while updating password", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *UserRepo) CreatePasswordReset(reset *PasswordReset) error {
+	repo.logger.Info("In CreatePasswordReset")
+
+	if result := repo.db.Create(reset); result.Error != nil {
+		repo.logger.Error("Error while creating password reset", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetPasswordResetByTokenHash(hash string) (*PasswordReset, error) {
+	repo.logger.Info("In GetPasswordResetByTokenHash")
+
+	var reset *PasswordReset
+	result := repo.db.Where("token_hash = ?", hash).Limit(1).Find(&reset)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching password reset from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Password reset not found by token hash")
+		return nil, common.ErrNotFound
+	}
+
+	return reset, nil
+}
+
+// UsePasswordReset marks token as used. If it was already used
+// by concurrent request ErrInvalidToken is returned
+func (repo *UserRepo) UsePasswordReset(id uint) error {
+	repo.logger.Info("In UsePasswordReset")
+
+	result := repo.db.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while using password reset", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrInvalidToken
+	}
+	return nil
+}
+
+func (repo *UserRepo) InvalidatePasswordResets(userid uint) error {
+	repo.logger.Info("In InvalidatePasswordResets")
+
+	result := repo.db.Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", userid).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while invalidating password resets", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) CreateVerification(verification *Verification) error {
+	repo.logger.Info("In CreateVerification")
+
+	if result := repo.db.Create(verification); result.Error != nil {
+		repo.logger.Error("Error while creating verification", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetVerificationByTokenHash(hash string) (*Verification, error) {
+	repo.logger.Info("In GetVerificationByTokenHash")
+
+	var verification *Verification
+	result := repo.db.Where("token_hash = ?", hash).Limit(1).Find(&verification)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching verification from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Verification not found by token hash")
+		return nil, common.ErrNotFound
+	}
+
+	return verification, nil
+}
+
+func (repo *UserRepo) UseVerification(id uint) error {
+	repo.logger.Info("In UseVerification")
+
+	result := repo.db.Model(&Verification{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while using verification", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrInvalidToken
+	}
+	return nil
+}
+
+// SetEmailVerified marks email of the user verified only if it is still the same email
+func (repo *UserRepo) SetEmailVerified(userid uint, email string) error {
+	repo.logger.Info("In SetEmailVerified")
+
+	result := repo.db.Model(&ContactInfo{}).
+		Where("id = (?) AND email = ?", repo.db.Table("users").Select("contact_info_id").Where("id = ?", userid), email).
+		Updates(map[string]interface{}{
+			"email_verified": true,
+			"version":        gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		repo.logger.Error("Error while setting email verified", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Email was changed after verification was sent")
+		return common.ErrInvalidToken
+	}
+	return nil
+}
+
+func (repo *UserRepo) IsEmailVerified(userid uint) (bool, error) {
+	var verified bool
+	result := repo.db.Table("contact_infos").Select("contact_infos.email_verified").
+		Joins("JOIN users ON users.contact_info_id = contact_infos.id").
+		Where("users.id = ?", userid).Limit(1).Scan(&verified)
+	if result.Error != nil {
+		repo.logger.Error("Error while checking email verified", zap.Error(result.Error))
+		return false, common.ErrInternalError
+	}
+	return verified, nil
+}
+
+func (repo *UserRepo) GetTOTP(userid uint) (*TOTP, error) {
+	repo.logger.Info("In GetTOTP")
+
+	var totp *TOTP
+	result := repo.db.Where("user_id = ?", userid).Limit(1).Find(&totp)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching totp from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	return totp, nil
+}
+
+// CreateTOTP replaces not confirmed enrollment of the user with new one
+func (repo *UserRepo) CreateTOTP(totp *TOTP) error {
+	repo.logger.Info("In CreateTOTP")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", totp.UserID).Delete(&TOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(totp).Error
+	})
+	if err != nil {
+		repo.logger.Error("Error while creating totp", zap.Error(err))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) ConfirmTOTP(id uint) error {
+	repo.logger.Info("In ConfirmTOTP")
+
+	result := repo.db.Model(&TOTP{}).Where("id = ?", id).Update("confirmed", true)
+	if result.Error != nil {
+		repo.logger.Error("Error while confirming totp", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// UseTOTPStep remembers the last used step, so every code works only once
+func (repo *UserRepo) UseTOTPStep(id uint, step int64) error {
+	repo.logger.Info("In UseTOTPStep")
+
+	result := repo.db.Model(&TOTP{}).Where("id = ? AND last_used_step < ?", id, step).Update("last_used_step", step)
+	if result.Error != nil {
+		repo.logger.Error("Error while using totp step", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		repo.logger.Info("Totp code was already used")
+		return common.ErrInvalidCode
+	}
+	return nil
+}
+
+func (repo *UserRepo) DeleteTOTP(userid uint) error {
+	repo.logger.Info("In DeleteTOTP")
+
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userid).Delete(&TOTP{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userid).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		repo.logger.Error("Error while deleting totp", zap.Error(err))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) ReplaceRecoveryCodes(userid uint, hashes []string) error {
+	repo.logger.Info("In ReplaceRecoveryCodes")
+
+	codes := make([]RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, RecoveryCode{UserID: userid, CodeHash: hash})
+	}
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userid).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		repo.logger.Error("Error while replacing recovery codes", zap.Error(err))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) UseRecoveryCode(userid uint, hash string) error {
+	repo.logger.Info("In UseRecoveryCode")
+
+	result := repo.db.Model(&RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userid, hash).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while using recovery code", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrInvalidCode
+	}
+	return nil
+}
+
+func (repo *UserRepo) CreateMFAChallenge(challenge *MFAChallenge) error {
+	repo.logger.Info("In CreateMFAChallenge")
+
+	if result := repo.db.Create(challenge); result.Error != nil {
+		repo.logger.Error("Error while creating mfa challenge", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetMFAChallengeByTokenHash(hash string) (*MFAChallenge, error) {
+	repo.logger.Info("In GetMFAChallengeByTokenHash")
+
+	var challenge *MFAChallenge
+	result := repo.db.Where("token_hash = ?", hash).Limit(1).Find(&challenge)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching mfa challenge from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	return challenge, nil
+}
+
+func (repo *UserRepo) IncrementMFAChallengeAttempts(id uint) error {
+	result := repo.db.Model(&MFAChallenge{}).Where("id = ?", id).Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		repo.logger.Error("Error while incrementing mfa challenge attempts", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) UseMFAChallenge(id uint) error {
+	repo.logger.Info("In UseMFAChallenge")
+
+	result := repo.db.Model(&MFAChallenge{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while using mfa challenge", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrInvalidToken
+	}
+	return nil
+}
+
+func (repo *UserRepo) IsMFARequired(userid uint) (bool, error) {
+	var count int64
+	result := repo.db.Model(&types.Role{}).
+		Joins("JOIN role_data ON role_data.id = roles.role_data_id").
+		Where("roles.user_id = ? AND role_data.require_mfa = true", userid).
+		Count(&count)
+	if result.Error != nil {
+		repo.logger.Error("Error while checking if mfa is required", zap.Error(result.Error))
+		return false, common.ErrInternalError
+	}
+	return count > 0, nil
+}
+
+func (repo *UserRepo) SetRoleRequireMFA(roledataid uint, required bool) error {
+	repo.logger.Info("In SetRoleRequireMFA")
+
+	result := repo.db.Model(&types.RoleData{}).Where("id = ?", roledataid).Update("require_mfa", required)
+	if result.Error != nil {
+		repo.logger.Error("Error while setting role require mfa", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+func (repo *UserRepo) CreateOIDCState(state *OIDCState) error {
+	repo.logger.Info("In CreateOIDCState")
+
+	if result := repo.db.Create(state); result.Error != nil {
+		repo.logger.Error("Error while creating oidc state", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetOIDCStateByHash(hash string) (*OIDCState, error) {
+	repo.logger.Info("In GetOIDCStateByHash")
+
+	var state *OIDCState
+	result := repo.db.Where("state_hash = ?", hash).Limit(1).Find(&state)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching oidc state from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	return state, nil
+}
+
+func (repo *UserRepo) UseOIDCState(id uint) error {
+	repo.logger.Info("In UseOIDCState")
+
+	result := repo.db.Model(&OIDCState{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while using oidc state", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrInvalidToken
+	}
+	return nil
+}
+
+func (repo *UserRepo) GetIdentity(provider string, subject string) (*Identity, error) {
+	repo.logger.Info("In GetIdentity")
+
+	var identity *Identity
+	result := repo.db.Where("provider = ? AND subject = ?", provider, subject).Limit(1).Find(&identity)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching identity from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	return identity, nil
+}
+
+func (repo *UserRepo) CreateIdentity(identity *Identity) error {
+	repo.logger.Info("In CreateIdentity")
+
+	if result := repo.db.Create(identity); result.Error != nil {
+		repo.logger.Error("Error while creating identity", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) CreateAPIToken(token *APIToken) error {
+	repo.logger.Info("In CreateAPIToken")
+
+	if result := repo.db.Create(token); result.Error != nil {
+		repo.logger.Error("Error while creating api token", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// GetAPITokens returns not revoked tokens of the user including expired ones
+func (repo *UserRepo) GetAPITokens(userid uint) ([]*APIToken, error) {
+	repo.logger.Info("In GetAPITokens")
+
+	tokens := make([]*APIToken, 0)
+	result := repo.db.Where("user_id = ? AND revoked_at IS NULL", userid).Order("id").Find(&tokens)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching api tokens from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+
+	return tokens, nil
+}
+
+func (repo *UserRepo) GetAPITokenByHash(hash string) (*APIToken, error) {
+	var token *APIToken
+	result := repo.db.Where("token_hash = ?", hash).Limit(1).Find(&token)
+	if result.Error != nil {
+		repo.logger.Error("Error while fetching api token from db", zap.Error(result.Error))
+		return nil, common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return nil, common.ErrNotFound
+	}
+
+	return token, nil
+}
+
+func (repo *UserRepo) TouchAPIToken(id uint) error {
+	result := repo.db.Model(&APIToken{}).Where("id = ?", id).Update("last_used_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while updating api token last use", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+// RevokeUserAPITokens revokes every personal access token of the user
+func (repo *UserRepo) RevokeUserAPITokens(userid uint) error {
+	repo.logger.Info("In RevokeUserAPITokens")
+
+	result := repo.db.Model(&APIToken{}).Where("user_id = ? AND revoked_at IS NULL", userid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking user api tokens", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	return nil
+}
+
+func (repo *UserRepo) RevokeAPIToken(userid uint, id uint) error {
+	repo.logger.Info("In RevokeAPIToken")
+
+	result := repo.db.Model(&APIToken{}).Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userid).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		repo.logger.Error("Error while revoking api token", zap.Error(result.Error))
+		return common.ErrInternalError
+	}
+	if result.RowsAffected == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
@@ -2,61 +2,47 @@ package user
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/policy"
+	"github.com/maxik12233/blog/types"
 )
 
 /* reqresp.go file stores golang structs, types and functions
    to perform request response logic */
 
-var ErrBadRequest = errors.New("Bad request")
-var ErrInvalidId = errors.New("Invalid id")
-var ErrNotFound = errors.New("Not found")
-var ErrInternalError = errors.New("Internal error")
-var ErrInvalidLoginOrPassword = errors.New("Invalid login or password")
-
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	switch err {
-	case ErrBadRequest:
-		w.WriteHeader(http.StatusBadRequest)
-	case ErrInvalidId, ErrNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case ErrInvalidLoginOrPassword:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
-}
-
 type UpdateLocationRequest struct {
-	ID uint `json:"id"`
+	ID      uint         `json:"id"`
+	Actor   policy.Actor `json:"-"`
+	IfMatch []string     `json:"-"`
 	Location
 }
 
 type UpdateUserPersonalInfoRequest struct {
-	ID uint `json:"id"`
+	ID      uint         `json:"id"`
+	Actor   policy.Actor `json:"-"`
+	IfMatch []string     `json:"-"`
 	PersonalInfo
 }
 
 type UpdateUserContactInfoRequest struct {
-	ID uint `json:"id"`
+	ID      uint         `json:"id"`
+	Actor   policy.Actor `json:"-"`
+	IfMatch []string     `json:"-"`
 	ContactInfo
 }
 
 type CreateUserRequest struct {
-	Login    string `json:"login"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required,min=3,max=32,login"`
+	Email    string `json:"email" validate:"omitempty,email,max=254"`
+	Password string `json:"password" validate:"required"`
 }
 
 type CreateUserResponse struct {
@@ -68,28 +54,225 @@ type GetUserRequest struct {
 	ID uint `json:"id"`
 }
 
+// UpdateResponse is message about update, new ETag of the resource is sent in header
+type UpdateResponse struct {
+	Message string
+	Tag     string
+}
+
+func (r UpdateResponse) ETag() string {
+	return r.Tag
+}
+
+func (r UpdateResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Message)
+}
+
 type GetUserResponse struct {
 	ID           uint         `json:"id"`
 	Login        string       `json:"login"`
+	CreatedAt    time.Time    `json:"createdat"`
 	PersonalInfo PersonalInfo `json:"personal"`
 	ContactInfo  ContactInfo  `json:"contact"`
 }
 
+func (r GetUserResponse) ETag() string {
+	return common.VersionTag(r.PersonalInfo.Version, r.ContactInfo.Version)
+}
+
+// GetAllUsersRequest is one page of users, all set filters must match.
+// Login is prefix of the login, CreatedTo is exclusive
+type GetAllUsersRequest struct {
+	Limit       int         `json:"limit" validate:"min=1,max=100"`
+	Cursor      *UserCursor `json:"cursor"`
+	Login       string      `json:"login" validate:"max=32"`
+	Role        string      `json:"role" validate:"max=64"`
+	Country     string      `json:"country" validate:"max=100"`
+	City        string      `json:"city" validate:"max=100"`
+	CreatedFrom *time.Time  `json:"createdfrom"`
+	CreatedTo   *time.Time  `json:"createdto"`
+	Sort        string      `json:"sort" validate:"oneof=id -id login -login createdat -createdat"`
+}
+
+// UserCursor points to the last user of the previous page. It is
+// valid only with the sort it was made for
+type UserCursor struct {
+	Sort      string    `json:"s"`
+	ID        uint      `json:"i"`
+	Login     string    `json:"l,omitempty"`
+	CreatedAt time.Time `json:"c"`
+}
+
 type GetAllUsersResponse struct {
-	Users []GetUserResponse
+	Users      []GetUserResponse `json:"users"`
+	Total      int64             `json:"total"`
+	NextCursor string            `json:"nextcursor,omitempty"`
 }
 
 type DeleteUserRequest struct {
-	ID uint `json:"id"`
+	ID    uint         `json:"id"`
+	Actor policy.Actor `json:"-"`
+}
+
+// ClientInfo describes device which sends the request
+type ClientInfo struct {
+	UserAgent string `json:"-"`
+	IP        string `json:"-"`
 }
 
 type LoginUserRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login    string `json:"login" validate:"required,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
+	NoCookie bool   `json:"nocookie"` // return tokens only in body, for non browser clients
+	ClientInfo
 }
 
 type LoginUserResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh,omitempty"`
+	MFARequired  bool   `json:"mfarequired,omitempty"`
+	MFAToken     string `json:"mfatoken,omitempty"`
+	MFAEnroll    bool   `json:"mfaenroll,omitempty"` // role requires MFA which the user hasn't enabled
+	NoCookie     bool   `json:"-"`
+}
+
+type VerifyMFARequest struct {
+	MFAToken     string `json:"mfatoken" validate:"required,max=256"`
+	Code         string `json:"code" validate:"omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery" validate:"max=32"`
+	NoCookie     bool   `json:"nocookie"`
+	ClientInfo
+}
+
+type OIDCLoginRequest struct {
+	Provider string `json:"provider"`
+}
+
+type OIDCLoginResponse struct {
+	URL   string `json:"url"`
+	State string `json:"-"`
+}
+
+type OIDCCallbackRequest struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code" validate:"required,max=2048"`
+	State    string `json:"state" validate:"required,max=256"`
+	ClientInfo
+}
+
+type CreateAPITokenRequest struct {
+	UserID        uint               `json:"-"`
+	Name          string             `json:"name" validate:"required,max=100"`
+	Scopes        []types.Permission `json:"scopes" validate:"required,min=1,max=50,dive,required,max=64"`
+	ExpiresInDays int                `json:"expiresindays" validate:"min=0"`
+}
+
+type CreateAPITokenResponse struct {
+	Token string `json:"token"` // shown only once
+	*APIToken
+}
+
+type APITokensRequest struct {
+	UserID  uint `json:"-"`
+	TokenID uint `json:"tokenid"`
+}
+
+type GetAPITokensResponse struct {
+	Tokens []*APIToken `json:"tokens"`
+}
+
+type TOTPRequest struct {
+	ID           uint   `json:"id"`
+	Code         string `json:"code" validate:"omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recovery" validate:"max=32"`
+}
+
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recoverycodes"`
+}
+
+type SetRoleRequireMFARequest struct {
+	RoleID   uint `json:"roleid"`
+	Required bool `json:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh" validate:"max=256"`
+	NoCookie     bool   `json:"nocookie"`
+	ClientInfo
+}
+
+type SessionsRequest struct {
+	UserID     uint   `json:"-"`
+	SessionID  string `json:"-"`
+	CurrentSID string `json:"-"` // session of the request, it is kept when other sessions are revoked
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"useragent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdat"`
+	LastSeenAt time.Time `json:"lastseenat"`
+	Current    bool      `json:"current"`
+}
+
+type GetSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+type LogoutAllRequest struct {
+	ID uint `json:"id"`
+}
+
+type RoleResponse struct {
+	ID          uint               `json:"id"`
+	Name        string             `json:"name"`
+	RequireMFA  bool               `json:"requiremfa"`
+	Permissions []types.Permission `json:"permissions"`
+}
+
+type GetRolesResponse struct {
+	Roles []RoleResponse `json:"roles"`
+}
+
+type UserRoleRequest struct {
+	ID     uint `json:"id"`
+	RoleID uint `json:"roleid"`
+}
+
+type GetUsersByRoleRequest struct {
+	RoleID uint `json:"roleid"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+	IP    string `json:"-"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required,max=256"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	ID              uint   `json:"-"`
+	CurrentPassword string `json:"current" validate:"required,max=1024"`
+	NewPassword     string `json:"new" validate:"required"`
+	CurrentSID      string `json:"-"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required,max=256"`
+}
+
+type ResendVerificationRequest struct {
+	ID uint `json:"id"`
 }
 
 func decodeUpdateLocationRequest(c context.Context, r *http.Request) (interface{}, error) {
@@ -97,14 +280,25 @@ func decodeUpdateLocationRequest(c context.Context, r *http.Request) (interface{
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		return nil, ErrInvalidId
+		return nil, common.ErrInvalidId
 	}
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.Actor, err = policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.IfMatch, err = common.IfMatch(r)
+	if err != nil {
+		return nil, err
 	}
 	req.ID = uint(id)
-	return req, err
+	return req, nil
 }
 
 func decodeUpdateUserPersonalInfoRequest(c context.Context, r *http.Request) (interface{}, error) {
@@ -112,14 +306,25 @@ func decodeUpdateUserPersonalInfoRequest(c context.Context, r *http.Request) (in
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		return nil, ErrInvalidId
+		return nil, common.ErrInvalidId
 	}
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.Actor, err = policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.IfMatch, err = common.IfMatch(r)
+	if err != nil {
+		return nil, err
 	}
 	req.ID = uint(id)
-	return req, err
+	return req, nil
 }
 
 func decodeUpdateUserContactInfoRequest(c context.Context, r *http.Request) (interface{}, error) {
@@ -127,21 +332,35 @@ func decodeUpdateUserContactInfoRequest(c context.Context, r *http.Request) (int
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		return nil, ErrInvalidId
+		return nil, common.ErrInvalidId
 	}
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.Actor, err = policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	req.IfMatch, err = common.IfMatch(r)
+	if err != nil {
+		return nil, err
 	}
 	req.ID = uint(id)
-	return req, err
+	return req, nil
 }
 
 func decodeCreateUserRequest(c context.Context, r *http.Request) (interface{}, error) {
 	var req CreateUserRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
 	}
 	return req, err
 }
@@ -150,10 +369,15 @@ func decodeDeleteUserRequest(ctx context.Context, r *http.Request) (interface{},
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	actor, err := policy.ActorFromRequest(r)
+	if err != nil {
+		return nil, err
 	}
 	return DeleteUserRequest{
-		ID: uint(id),
+		ID:    uint(id),
+		Actor: actor,
 	}, nil
 }
 
@@ -161,11 +385,118 @@ func decodeLoginUserRequest(ctx context.Context, r *http.Request) (interface{},
 	var req LoginUserRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
 	}
 	return LoginUserRequest{
-		Login:    req.Login,
-		Password: req.Password,
+		Login:      req.Login,
+		Password:   req.Password,
+		NoCookie:   req.NoCookie,
+		ClientInfo: clientInfo(r),
+	}, nil
+}
+
+func decodeRefreshTokenRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req RefreshTokenRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return nil, common.ErrBadRequest
+		}
+	}
+	// Browser clients send refresh token only in cookie
+	if req.RefreshToken == "" {
+		cookie, err := r.Cookie(common.REFRESH_TOKEN_NAME)
+		if err != nil {
+			return nil, common.ErrUnauthorized
+		}
+		req.RefreshToken = cookie.Value
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.ClientInfo = clientInfo(r)
+	return req, nil
+}
+
+func decodeChangePasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ChangePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	req.ID = userid
+	req.CurrentSID, _ = middleware.GetSessionID(ctx)
+	return req, nil
+}
+
+func decodeSessionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	sid, _ := middleware.GetSessionID(ctx)
+	return SessionsRequest{
+		UserID:     userid,
+		SessionID:  mux.Vars(r)["sessionid"],
+		CurrentSID: sid,
+	}, nil
+}
+
+// decodeAdminSessionsRequest reads user from path, admin's own
+// current session is kept when all sessions are revoked
+func decodeAdminSessionsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil || id <= 0 {
+		return nil, common.ErrInvalidId
+	}
+	req := SessionsRequest{
+		UserID:    uint(id),
+		SessionID: params["sessionid"],
+	}
+	if userid, ok := middleware.GetUserID(ctx); ok && userid == req.UserID {
+		req.CurrentSID, _ = middleware.GetSessionID(ctx)
+	}
+	return req, nil
+}
+
+func clientInfo(r *http.Request) ClientInfo {
+	useragent := r.UserAgent()
+	if len(useragent) > 255 {
+		useragent = useragent[:255]
+	}
+	return ClientInfo{
+		UserAgent: useragent,
+		IP:        middleware.ClientIP(r),
+	}
+}
+
+func decodeLogoutRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	req, err := decodeRefreshTokenRequest(ctx, r)
+	if err == common.ErrUnauthorized {
+		// Nothing to revoke, but cookies still have to be cleared
+		return RefreshTokenRequest{}, nil
+	}
+	return req, err
+}
+
+func decodeLogoutAllRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	return LogoutAllRequest{
+		ID: userid,
 	}, nil
 }
 
@@ -173,18 +504,277 @@ func decodeGetOneUserRequest(ctx context.Context, r *http.Request) (interface{},
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
 	if err != nil {
-		return nil, ErrBadRequest
+		return nil, common.ErrBadRequest
 	}
 	return GetUserRequest{
 		ID: uint(id),
 	}, nil
 }
 
-func decodeGetAllUsersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+func decodeGetRolesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 	return nil, nil
 }
 
-func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+func decodeUserRoleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	params := mux.Vars(r)
+	id, err := strconv.Atoi(params["id"])
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	roleid, err := strconv.Atoi(params["roleid"])
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	return UserRoleRequest{
+		ID:     uint(id),
+		RoleID: uint(roleid),
+	}, nil
+}
+
+func decodeGetUsersByRoleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	params := mux.Vars(r)
+	roleid, err := strconv.Atoi(params["roleid"])
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	return GetUsersByRoleRequest{
+		RoleID: uint(roleid),
+	}, nil
+}
+
+func decodeForgotPasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.IP = middleware.ClientIP(r)
+	return req, nil
+}
+
+func decodeResetPasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeVerifyEmailRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyEmailRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func decodeResendVerificationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	return ResendVerificationRequest{
+		ID: userid,
+	}, nil
+}
+
+func decodeVerifyMFARequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyMFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.ClientInfo = clientInfo(r)
+	return req, nil
+}
+
+func decodeOIDCLoginRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	params := mux.Vars(r)
+	return OIDCLoginRequest{
+		Provider: params["provider"],
+	}, nil
+}
+
+// decodeOIDCCallbackRequest also checks that state belongs to this browser,
+// so nobody can log the user in to another account with own code
+func decodeOIDCCallbackRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	params := mux.Vars(r)
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		return nil, common.ErrUnauthorized
+	}
+	req := OIDCCallbackRequest{
+		Provider: params["provider"],
+		Code:     query.Get("code"),
+		State:    query.Get("state"),
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	cookie, err := r.Cookie(common.OIDC_STATE_COOKIE_NAME)
+	if err != nil || cookie.Value != req.State {
+		return nil, common.ErrUnauthorized
+	}
+	req.ClientInfo = clientInfo(r)
+	return req, nil
+}
+
+func decodeCreateAPITokenRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateAPITokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	req.UserID = userid
+	return req, nil
+}
+
+func decodeAPITokensRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req APITokensRequest
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	req.UserID = userid
+	if val, ok := mux.Vars(r)["tokenid"]; ok {
+		tokenid, err := strconv.Atoi(val)
+		if err != nil || tokenid <= 0 {
+			return nil, common.ErrInvalidId
+		}
+		req.TokenID = uint(tokenid)
+	}
+	return req, nil
+}
+
+func decodeTOTPRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req TOTPRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			return nil, common.ErrBadRequest
+		}
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	userid, ok := middleware.GetUserID(ctx)
+	if !ok {
+		return nil, common.ErrUnauthorized
+	}
+	req.ID = userid
+	return req, nil
+}
+
+func decodeSetRoleRequireMFARequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req SetRoleRequireMFARequest
+	params := mux.Vars(r)
+	roleid, err := strconv.Atoi(params["roleid"])
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, common.ErrBadRequest
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	req.RoleID = uint(roleid)
+	return req, nil
+}
+
+func decodeGetAllUsersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	req := GetAllUsersRequest{
+		Limit:   common.USERS_PAGE_LIMIT,
+		Login:   query.Get("login"),
+		Role:    query.Get("role"),
+		Country: query.Get("country"),
+		City:    query.Get("city"),
+		Sort:    "id",
+	}
+	if val := query.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, common.InvalidField("limit")
+		}
+		req.Limit = limit
+	}
+	if val := query.Get("sort"); val != "" {
+		req.Sort = val
+	}
+	for name, field := range map[string]**time.Time{"createdfrom": &req.CreatedFrom, "createdto": &req.CreatedTo} {
+		if val := query.Get(name); val != "" {
+			date, err := parseDate(val)
+			if err != nil {
+				return nil, common.InvalidField(name)
+			}
+			*field = &date
+		}
+	}
+	if err := common.Validate(req); err != nil {
+		return nil, err
+	}
+	if val := query.Get("cursor"); val != "" {
+		cursor, err := parseUserCursor(val)
+		if err != nil || cursor.Sort != req.Sort {
+			return nil, common.InvalidField("cursor")
+		}
+		req.Cursor = cursor
+	}
+	return req, nil
+}
+
+// parseDate accepts RFC 3339 timestamp or date
+func parseDate(val string) (time.Time, error) {
+	if date, err := time.Parse(time.RFC3339, val); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.DateOnly, val)
+}
+
+func (c *UserCursor) String() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func parseUserCursor(val string) (*UserCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	var cursor UserCursor
+	if err := json.Unmarshal(buf, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if common.WriteETag(ctx, w, response) {
+		return nil
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
@@ -192,16 +782,80 @@ func encodeResponse(_ context.Context, w http.ResponseWriter, response interface
 func encodeLoginUserResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
 
 	resp := response.(LoginUserResponse)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if resp.NoCookie || resp.MFARequired {
+		return json.NewEncoder(w).Encode(response)
+	}
+
 	// set cookie for storing token
 	cookie := http.Cookie{}
 	cookie.Name = common.JWT_TOKEN_NAME
 	cookie.Value = resp.Token
-	cookie.Expires = time.Now().Add(time.Hour * time.Duration(common.JWT_TOKEN_EXP_HOURS))
+	cookie.Expires = time.Now().Add(time.Minute * time.Duration(common.JWT_TOKEN_EXP_MINUTES))
 	cookie.Secure = false
 	cookie.HttpOnly = true
 	cookie.Path = "/"
 	http.SetCookie(w, &cookie)
 
+	// set cookie for storing refresh token, it is needed only by user service
+	refreshCookie := http.Cookie{}
+	refreshCookie.Name = common.REFRESH_TOKEN_NAME
+	refreshCookie.Value = resp.RefreshToken
+	refreshCookie.Expires = time.Now().Add(time.Hour * time.Duration(common.REFRESH_TOKEN_EXP_HOURS))
+	refreshCookie.Secure = false
+	refreshCookie.HttpOnly = true
+	refreshCookie.Path = "/v1/user/"
+	http.SetCookie(w, &refreshCookie)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+// encodeOIDCLoginResponse redirects browser to the provider's login page
+func encodeOIDCLoginResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(OIDCLoginResponse)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     common.OIDC_STATE_COOKIE_NAME,
+		Value:    resp.State,
+		MaxAge:   common.OIDC_STATE_EXP_MINUTES * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/v1/user/oidc/",
+	})
+	w.Header().Set("Location", resp.URL)
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
+
+func encodeOIDCCallbackResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     common.OIDC_STATE_COOKIE_NAME,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/v1/user/oidc/",
+	})
+	return encodeLoginUserResponse(ctx, w, response)
+}
+
+func encodeLogoutResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+
+	// clear cookies with tokens
+	http.SetCookie(w, &http.Cookie{
+		Name:     common.JWT_TOKEN_NAME,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     common.REFRESH_TOKEN_NAME,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/v1/user/",
+	})
+
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
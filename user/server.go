@@ -3,23 +3,30 @@ package user
 import (
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"github.com/maxik12233/blog/common"
 	"github.com/maxik12233/blog/middleware"
 	"github.com/maxik12233/blog/types"
 )
 
 func CreateNewServer(rg *mux.Router, endpoints UserEndpoints) {
 	options := []httptransport.ServerOption{
-		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerErrorEncoder(common.EncodeError),
+		httptransport.ServerBefore(common.PopulateIfNoneMatch),
 	}
 
 	usergroupAuth := rg.PathPrefix("/user").Subrouter()
 	usergroupAuth.Use(middleware.LoggingMiddleware)
-	usergroupAuth.Use(middleware.ValidateRolesMiddleware(
-		[]uint{
-			uint(types.RoleCommon),
-		},
-	))
+	usergroupAuth.Use(middleware.RequirePermissions(types.PermUserRead))
 	usergroupNoAuth := rg.PathPrefix("/user").Subrouter()
+	// Roles which require MFA give no permissions until TOTP is confirmed,
+	// so enrollment needs only authorized user and no permissions
+	mfagroup := rg.PathPrefix("/user/mfa").Subrouter()
+	mfagroup.Use(middleware.LoggingMiddleware)
+	mfagroup.Use(middleware.RejectAPITokens)
+	admingroup := rg.PathPrefix("/admin").Subrouter()
+	admingroup.Use(middleware.LoggingMiddleware)
+	admingroup.Use(middleware.RequirePermissions(types.PermUserManage))
+	noAPIToken := middleware.RejectAPITokens
 
 	usergroupAuth.Methods("PUT").Path("/{id}/personal/location").Handler(httptransport.NewServer(
 		endpoints.UpdateLocation,
@@ -35,12 +42,12 @@ func CreateNewServer(rg *mux.Router, endpoints UserEndpoints) {
 		options...,
 	))
 
-	usergroupAuth.Methods("PUT").Path("/{id}/contact").Handler(httptransport.NewServer(
+	usergroupAuth.Methods("PUT").Path("/{id}/contact").Handler(noAPIToken(httptransport.NewServer(
 		endpoints.UpdateUserContactInfo,
 		decodeUpdateUserContactInfoRequest,
 		encodeResponse,
 		options...,
-	))
+	)))
 
 	usergroupNoAuth.Methods("POST").Path("/").Handler(httptransport.NewServer(
 		endpoints.CreateUser,
@@ -49,12 +56,33 @@ func CreateNewServer(rg *mux.Router, endpoints UserEndpoints) {
 		options...,
 	))
 
-	usergroupAuth.Methods("DELETE").Path("/{id}").Handler(httptransport.NewServer(
+	usergroupAuth.Methods("GET").Path("/sessions").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.GetSessions,
+		decodeSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("DELETE").Path("/sessions/{sessionid}").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeSession,
+		decodeSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("DELETE").Path("/sessions").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeOtherSessions,
+		decodeSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("DELETE").Path("/{id}").Handler(noAPIToken(httptransport.NewServer(
 		endpoints.DeleteUser,
 		decodeDeleteUserRequest,
 		encodeResponse,
 		options...,
-	))
+	)))
 
 	usergroupNoAuth.Methods("PUT").Path("/").Handler(httptransport.NewServer(
 		endpoints.LoginUser,
@@ -63,6 +91,125 @@ func CreateNewServer(rg *mux.Router, endpoints UserEndpoints) {
 		options...,
 	))
 
+	usergroupNoAuth.Methods("POST").Path("/refresh").Handler(httptransport.NewServer(
+		endpoints.RefreshToken,
+		decodeRefreshTokenRequest,
+		encodeLoginUserResponse,
+		options...,
+	))
+
+	usergroupNoAuth.Methods("POST").Path("/logout").Handler(httptransport.NewServer(
+		endpoints.Logout,
+		decodeLogoutRequest,
+		encodeLogoutResponse,
+		options...,
+	))
+
+	usergroupAuth.Methods("POST").Path("/logout/all").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.LogoutAll,
+		decodeLogoutAllRequest,
+		encodeLogoutResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("PUT").Path("/password").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.ChangePassword,
+		decodeChangePasswordRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupNoAuth.Methods("POST").Path("/password/forgot").Handler(httptransport.NewServer(
+		endpoints.ForgotPassword,
+		decodeForgotPasswordRequest,
+		encodeResponse,
+		options...,
+	))
+
+	usergroupNoAuth.Methods("POST").Path("/password/reset").Handler(httptransport.NewServer(
+		endpoints.ResetPassword,
+		decodeResetPasswordRequest,
+		encodeResponse,
+		options...,
+	))
+
+	usergroupNoAuth.Methods("POST").Path("/email/verify").Handler(httptransport.NewServer(
+		endpoints.VerifyEmail,
+		decodeVerifyEmailRequest,
+		encodeResponse,
+		options...,
+	))
+
+	usergroupAuth.Methods("POST").Path("/email/resend").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.ResendVerification,
+		decodeResendVerificationRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupNoAuth.Methods("POST").Path("/mfa/verify").Handler(httptransport.NewServer(
+		endpoints.VerifyMFA,
+		decodeVerifyMFARequest,
+		encodeLoginUserResponse,
+		options...,
+	))
+
+	usergroupNoAuth.Methods("GET").Path("/oidc/{provider}/login").Handler(httptransport.NewServer(
+		endpoints.OIDCLogin,
+		decodeOIDCLoginRequest,
+		encodeOIDCLoginResponse,
+		options...,
+	))
+
+	usergroupNoAuth.Methods("GET").Path("/oidc/{provider}/callback").Handler(httptransport.NewServer(
+		endpoints.OIDCCallback,
+		decodeOIDCCallbackRequest,
+		encodeOIDCCallbackResponse,
+		options...,
+	))
+
+	mfagroup.Methods("POST").Path("/totp").Handler(httptransport.NewServer(
+		endpoints.EnrollTOTP,
+		decodeTOTPRequest,
+		encodeResponse,
+		options...,
+	))
+
+	mfagroup.Methods("POST").Path("/totp/confirm").Handler(httptransport.NewServer(
+		endpoints.ConfirmTOTP,
+		decodeTOTPRequest,
+		encodeResponse,
+		options...,
+	))
+
+	usergroupAuth.Methods("DELETE").Path("/mfa/totp").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.DisableTOTP,
+		decodeTOTPRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("POST").Path("/tokens").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.CreateAPIToken,
+		decodeCreateAPITokenRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("GET").Path("/tokens").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.GetAPITokens,
+		decodeAPITokensRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	usergroupAuth.Methods("DELETE").Path("/tokens/{tokenid}").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeAPIToken,
+		decodeAPITokensRequest,
+		encodeResponse,
+		options...,
+	)))
+
 	usergroupAuth.Methods("GET").Path("/{id}").Handler(httptransport.NewServer(
 		endpoints.GetOneUser,
 		decodeGetOneUserRequest,
@@ -77,4 +224,60 @@ func CreateNewServer(rg *mux.Router, endpoints UserEndpoints) {
 		options...,
 	))
 
+	admingroup.Methods("GET").Path("/roles").Handler(httptransport.NewServer(
+		endpoints.GetRoles,
+		decodeGetRolesRequest,
+		encodeResponse,
+		options...,
+	))
+
+	admingroup.Methods("GET").Path("/roles/{roleid}/users").Handler(httptransport.NewServer(
+		endpoints.GetUsersByRole,
+		decodeGetUsersByRoleRequest,
+		encodeResponse,
+		options...,
+	))
+
+	admingroup.Methods("PUT").Path("/roles/{roleid}/mfa").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.SetRoleRequireMFA,
+		decodeSetRoleRequireMFARequest,
+		encodeResponse,
+		options...,
+	)))
+
+	admingroup.Methods("GET").Path("/users/{id}/sessions").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.GetSessions,
+		decodeAdminSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	admingroup.Methods("DELETE").Path("/users/{id}/sessions/{sessionid}").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeSession,
+		decodeAdminSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	admingroup.Methods("DELETE").Path("/users/{id}/sessions").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeOtherSessions,
+		decodeAdminSessionsRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	admingroup.Methods("POST").Path("/users/{id}/roles/{roleid}").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.GrantRole,
+		decodeUserRoleRequest,
+		encodeResponse,
+		options...,
+	)))
+
+	admingroup.Methods("DELETE").Path("/users/{id}/roles/{roleid}").Handler(noAPIToken(httptransport.NewServer(
+		endpoints.RevokeRole,
+		decodeUserRoleRequest,
+		encodeResponse,
+		options...,
+	)))
+
 }
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/types"
+)
+
+func newTestRouter(s UserService) http.Handler {
+	router := mux.NewRouter()
+	CreateNewServer(router.PathPrefix("/v1").Subrouter(), MakeUserEndpoints(s))
+	return router
+}
+
+// serve sends request with access token and decodes response body into out
+func serve(t *testing.T, router http.Handler, method string, path string, token string, body interface{}, out interface{}) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if out != nil && rec.Code < 300 {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestUserWithRequiredMFACompletesEnrollment(t *testing.T) {
+	// The only role of the user requires MFA, so the user has no permissions at all
+	repo := newMemRepo(&User{ID: 1, Login: "alice", Password: hashPassword(t, "password")})
+	repo.requireMFA[1] = true
+	s, _ := newTestService(t, repo)
+	router := newTestRouter(s)
+
+	resp, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !resp.MFAEnroll || resp.Token == "" {
+		t.Fatalf("login returned %+v", resp)
+	}
+	if code := serve(t, router, "GET", "/v1/user/tokens", resp.Token, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("route which needs permissions: got %d, want 403", code)
+	}
+
+	var enroll EnrollTOTPResponse
+	if code := serve(t, router, "POST", "/v1/user/mfa/totp", resp.Token, nil, &enroll); code != http.StatusOK {
+		t.Fatalf("enroll: got %d, want 200", code)
+	}
+	if enroll.Secret == "" || enroll.URI == "" {
+		t.Fatalf("enroll returned %+v", enroll)
+	}
+
+	step := totpStep(time.Now())
+	code, err := totpCode(enroll.Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirm ConfirmTOTPResponse
+	if status := serve(t, router, "POST", "/v1/user/mfa/totp/confirm", resp.Token, TOTPRequest{Code: code}, &confirm); status != http.StatusOK {
+		t.Fatalf("confirm: got %d, want 200", status)
+	}
+	if len(confirm.RecoveryCodes) == 0 {
+		t.Fatal("confirm returned no recovery codes")
+	}
+
+	// Now login asks for the second factor and the role gives its permissions
+	resp, err = s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("login after enrollment: %v", err)
+	}
+	if !resp.MFARequired || resp.MFAEnroll {
+		t.Fatalf("login after enrollment returned %+v", resp)
+	}
+	next, err := totpCode(enroll.Secret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: resp.MFAToken, Code: next}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	perms, err := s.GetUserPermissions(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(perms, types.PermUserRead) {
+		t.Fatalf("permissions of the role are not given after enrollment: %v", perms)
+	}
+}
+
+func TestDisableTOTPNeedsPermissions(t *testing.T) {
+	repo := newMemRepo(&User{ID: 1, Login: "alice", Password: hashPassword(t, "password")})
+	repo.requireMFA[1] = true
+	s, _ := newTestService(t, repo)
+
+	resp, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if code := serve(t, newTestRouter(s), "DELETE", "/v1/user/mfa/totp", resp.Token, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("disable: got %d, want 403", code)
+	}
+}
+
+// patStore authenticates every personal access token as token 1 of user 1
+type patStore struct {
+	scopes []types.Permission
+}
+
+func (p patStore) AuthenticateAPIToken(hash string) (uint, uint, []types.Permission, error) {
+	return 1, 1, p.scopes, nil
+}
+
+func TestAdminRoutesRejectAPITokens(t *testing.T) {
+	middleware.SetAPITokenStore(patStore{scopes: types.DefaultRolePermissions[types.RoleAdmin]})
+	t.Cleanup(func() { middleware.SetAPITokenStore(nil) })
+	s, _ := newTestService(t, newMemRepo())
+	router := newTestRouter(s)
+	token := common.API_TOKEN_PREFIX + "leaked"
+
+	routes := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{method: "POST", path: "/v1/admin/users/2/roles/3"},
+		{method: "DELETE", path: "/v1/admin/users/2/roles/3"},
+		{method: "PUT", path: "/v1/admin/roles/3/mfa", body: SetRoleRequireMFARequest{Required: false}},
+	}
+	for _, route := range routes {
+		if code := serve(t, router, route.method, route.path, token, route.body, nil); code != http.StatusForbidden {
+			t.Errorf("%s %s: got %d, want 403", route.method, route.path, code)
+		}
+	}
+}
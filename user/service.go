@@ -1,44 +1,116 @@
 package user
 
 import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/lockout"
+	"github.com/maxik12233/blog/mailer"
 	"github.com/maxik12233/blog/middleware"
+	"github.com/maxik12233/blog/oidc"
+	"github.com/maxik12233/blog/policy"
 	"github.com/maxik12233/blog/types"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserService interface {
 	CreateUser(req *CreateUserRequest) (uint, error)
-	LoginUser(login string, pass string) (string, error)
-	DeleteUser(id uint) error
-	GetAll() ([]*User, error)
+	LoginUser(req *LoginUserRequest) (*LoginUserResponse, error)
+	VerifyMFA(req *VerifyMFARequest) (*LoginUserResponse, error)
+	EnrollTOTP(id uint) (string, string, error)
+	ConfirmTOTP(id uint, code string) ([]string, error)
+	DisableTOTP(id uint, code string, recovery string) error
+	SetRoleRequireMFA(roledataid uint, required bool) error
+	OIDCLogin(provider string) (string, string, error)
+	CreateAPIToken(req *CreateAPITokenRequest) (string, *APIToken, error)
+	GetAPITokens(userid uint) ([]*APIToken, error)
+	RevokeAPIToken(userid uint, id uint) error
+	AuthenticateAPIToken(hash string) (uint, uint, []types.Permission, error)
+	GetSessions(userid uint) ([]*Session, error)
+	RevokeSession(userid uint, sid string) error
+	RevokeOtherSessions(userid uint, currentsid string) error
+	OIDCCallback(req *OIDCCallbackRequest) (*LoginUserResponse, error)
+	RefreshToken(req *RefreshTokenRequest) (string, string, error)
+	Logout(refresh string) error
+	RevokeAllTokens(id uint) error
+	DeleteUser(req *DeleteUserRequest) error
+	GetAll(req *GetAllUsersRequest) ([]*User, int64, *UserCursor, error)
 	GetOne(id uint) (*User, error)
-	UpdateContactInfo(req *UpdateUserContactInfoRequest) error
-	UpdatePersonalInfo(req *UpdateUserPersonalInfoRequest) error
-	UpdateLocation(req *UpdateLocationRequest) error
+	UpdateContactInfo(req *UpdateUserContactInfoRequest) (string, error)
+	UpdatePersonalInfo(req *UpdateUserPersonalInfoRequest) (string, error)
+	UpdateLocation(req *UpdateLocationRequest) (string, error)
+	GetRoles() ([]*types.RoleData, error)
+	GrantRole(userid uint, roledataid uint) error
+	RevokeRole(userid uint, roledataid uint) error
+	GetUsersByRole(roledataid uint) ([]*User, error)
+	GetUserPermissions(id uint) ([]types.Permission, error)
+	VerifyEmail(token string) error
+	ResendVerification(id uint) error
+	ForgotPassword(req *ForgotPasswordRequest) error
+	ResetPassword(req *ResetPasswordRequest) error
+	ChangePassword(req *ChangePasswordRequest) error
 }
 
 type UserServiceImpl struct {
-	repo   UserRepository
-	logger *zap.Logger
+	repo      UserRepository
+	policy    policy.Policy
+	mailer    mailer.Mailer
+	limiter   *lockout.Limiter
+	providers map[string]*oidc.Provider
+	passwords *PasswordPolicy
+	hasher    PasswordHasher
+	logger    *zap.Logger
+
+	// dummyHash is verified when there is no password to compare with,
+	// so unknown logins take as long as wrong passwords
+	dummyHash string
 }
 
-func NewUserService(repo UserRepository, logger *zap.Logger) UserService {
+func NewUserService(repo UserRepository, policy policy.Policy, mailer mailer.Mailer, limiter *lockout.Limiter, providers map[string]*oidc.Provider, passwords *PasswordPolicy, hasher PasswordHasher, logger *zap.Logger) UserService {
+	dummyHash, err := hasher.Hash("dummy password")
+	if err != nil {
+		logger.Error("Error while hashing dummy password", zap.Error(err))
+	}
 	return &UserServiceImpl{
-		repo:   repo,
-		logger: logger,
+		repo:      repo,
+		policy:    policy,
+		mailer:    mailer,
+		limiter:   limiter,
+		providers: providers,
+		passwords: passwords,
+		hasher:    hasher,
+		logger:    logger,
+		dummyHash: dummyHash,
 	}
 }
 
 func (s *UserServiceImpl) CreateUser(req *CreateUserRequest) (uint, error) {
 	s.logger.Info("In CreateUser")
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 10)
+	if req.Email != "" && !isValidEmail(req.Email) {
+		return 0, common.ErrBadRequest
+	}
+	if err := s.passwords.Check(req.Password, req.Login); err != nil {
+		s.logger.Info("Password doesn't follow password policy")
+		return 0, err
+	}
+
+	hash, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		s.logger.Error("Error while hashing the password", zap.Error(err))
-		return 0, ErrInternalError
+		return 0, common.ErrInternalError
+	}
+	req.Password = hash
+
+	roledata, err := s.repo.GetRoleDataByName(types.RoleCommon)
+	if err != nil {
+		s.logger.Error("Error while getting default role", zap.Error(err))
+		return 0, common.ErrInternalError
 	}
-	req.Password = string(hash)
 
 	user := User{
 		Login:    req.Login,
@@ -50,8 +122,7 @@ func (s *UserServiceImpl) CreateUser(req *CreateUserRequest) (uint, error) {
 			Location: &Location{},
 		},
 		Role: []types.Role{
-			{RoleDataID: 1},
-			{RoleDataID: 2},
+			{RoleDataID: roledata.ID},
 		},
 	}
 
@@ -61,104 +132,499 @@ func (s *UserServiceImpl) CreateUser(req *CreateUserRequest) (uint, error) {
 		return 0, err
 	}
 
+	// User is already created, so failed email is only logged and can be sent again
+	if req.Email != "" {
+		if err := s.sendVerification(id, req.Login, req.Email); err != nil {
+			s.logger.Error("Error while sending verification email", zap.Error(err))
+		}
+	}
+
 	return id, nil
 
 }
 
-func (s *UserServiceImpl) UpdateLocation(req *UpdateLocationRequest) error {
+// UpdateLocation replaces location of the user if profile wasn't changed
+// since client got its ETag. New ETag is returned
+func (s *UserServiceImpl) UpdateLocation(req *UpdateLocationRequest) (string, error) {
 	s.logger.Info("In UpdateLocation")
 
+	err := s.policy.Enforce(req.Actor, req.ID, "", types.PermUserManage, "user:update:location", req.ID)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.getProfile(req.ID, req.IfMatch)
+	if err != nil {
+		return "", err
+	}
+
 	loc := Location{
 		Country: req.Country,
 		City:    req.City,
 	}
 
-	err := s.repo.UpdateLocation(req.ID, &loc)
+	err = s.repo.UpdateLocation(req.ID, &loc, user.PersonalInfo.Version)
 	if err != nil {
 		s.logger.Error("Error while updating location", zap.Error(err))
-		return err
+		return "", err
 	}
+	user.PersonalInfo.Version++
 
-	return nil
+	return profileTag(user), nil
 }
 
-func (s *UserServiceImpl) UpdatePersonalInfo(req *UpdateUserPersonalInfoRequest) error {
+// UpdatePersonalInfo replaces personal info of the user if profile wasn't
+// changed since client got its ETag. New ETag is returned
+func (s *UserServiceImpl) UpdatePersonalInfo(req *UpdateUserPersonalInfoRequest) (string, error) {
 	s.logger.Info("In UpdatePersonalInfo")
 
+	err := s.policy.Enforce(req.Actor, req.ID, "", types.PermUserManage, "user:update:personal", req.ID)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.getProfile(req.ID, req.IfMatch)
+	if err != nil {
+		return "", err
+	}
+
 	pi := PersonalInfo{
 		PersonalStatus: req.PersonalStatus,
 		Description:    req.Description,
 		FirstName:      req.FirstName,
 		LastName:       req.LastName,
+		Version:        user.PersonalInfo.Version,
 	}
 
-	err := s.repo.UpdateUserPersonalInfo(req.ID, &pi)
+	err = s.repo.UpdateUserPersonalInfo(req.ID, &pi)
 	if err != nil {
 		s.logger.Error("Error while updating personal info", zap.Error(err))
-		return err
+		return "", err
 	}
+	user.PersonalInfo.Version++
 
-	return nil
+	return profileTag(user), nil
 }
 
-func (s *UserServiceImpl) UpdateContactInfo(req *UpdateUserContactInfoRequest) error {
+// UpdateContactInfo replaces contact info of the user if profile wasn't
+// changed since client got its ETag. New ETag is returned
+func (s *UserServiceImpl) UpdateContactInfo(req *UpdateUserContactInfoRequest) (string, error) {
 	s.logger.Info("In UpdateContactInfo")
 
+	err := s.policy.Enforce(req.Actor, req.ID, "", types.PermUserManage, "user:update:contact", req.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if req.Email != "" && !isValidEmail(req.Email) {
+		return "", common.ErrBadRequest
+	}
+
+	user, err := s.getProfile(req.ID, req.IfMatch)
+	if err != nil {
+		return "", err
+	}
+
+	// New email has to be verified again
+	emailChanged := user.ContactInfo.Email != req.Email
 	ci := ContactInfo{
-		Email:  req.Email,
-		Mobile: req.Mobile,
+		Email:         req.Email,
+		Mobile:        req.Mobile,
+		EmailVerified: !emailChanged && user.ContactInfo.EmailVerified,
+		Version:       user.ContactInfo.Version,
 	}
 
-	err := s.repo.UpdateUserContactInfo(req.ID, &ci)
+	err = s.repo.UpdateUserContactInfo(req.ID, &ci)
 	if err != nil {
 		s.logger.Error("Error while updating contact info", zap.Error(err))
-		return err
+		return "", err
 	}
+	user.ContactInfo.Version++
 
-	return nil
+	if emailChanged && req.Email != "" {
+		if err := s.sendVerification(user.ID, user.Login, req.Email); err != nil {
+			s.logger.Error("Error while sending verification email", zap.Error(err))
+		}
+	}
+
+	return profileTag(user), nil
+}
+
+// getProfile returns user whose profile is going to be updated, if
+// profile's ETag matches ifmatch
+func (s *UserServiceImpl) getProfile(id uint, ifmatch []string) (*User, error) {
+	user, err := s.repo.GetOneById(id)
+	if err != nil {
+		s.logger.Error("Error while getting user to update profile", zap.Error(err))
+		return nil, err
+	}
+	if user.PersonalInfo == nil || user.ContactInfo == nil {
+		s.logger.Error("User has no profile", zap.Uint("id", id))
+		return nil, common.ErrInternalError
+	}
+	if !common.MatchTag(ifmatch, profileTag(user)) {
+		s.logger.Info("Profile was changed since client got it", zap.Uint("id", id))
+		return nil, common.ErrPreconditionFailed
+	}
+	return user, nil
+}
+
+// profileTag is ETag of the user, it is the same as ETag of GetUserResponse
+func profileTag(user *User) string {
+	return common.VersionTag(user.PersonalInfo.Version, user.ContactInfo.Version)
 }
 
-func (s *UserServiceImpl) LoginUser(login string, pass string) (string, error) {
+// LoginUser checks login and password. For users with TOTP only mfa
+// token is returned, which is exchanged for access tokens by VerifyMFA.
+// Failed attempts are counted per login and per client ip
+func (s *UserServiceImpl) LoginUser(req *LoginUserRequest) (*LoginUserResponse, error) {
 	s.logger.Info("In LoginUser")
 
-	var user *User
-	var roles []uint
-	user, err := s.repo.GetOneByLogin(login)
+	loginKey, ipKey := lockout.LoginKey(req.Login), lockout.IPKey(req.IP)
+	if err := s.limiter.Check(loginKey, ipKey); err != nil {
+		s.logger.Info("Login attempt is rejected, too many failed attempts", zap.String("login", req.Login), zap.String("ip", req.IP))
+		return nil, err
+	}
+
+	user, err := s.repo.GetOneByLogin(req.Login)
 	if err != nil {
 		s.logger.Error("Error while getting user by login", zap.Error(err))
 		switch err {
-		case ErrNotFound:
-			return "", ErrInvalidLoginOrPassword
+		case common.ErrNotFound:
+			s.hasher.Verify(s.dummyHash, req.Password)
+			return nil, s.loginFailed(loginKey, ipKey)
 		default:
-			return "", err
+			return nil, err
+		}
+	}
+
+	// Compare sent pass with hash pass
+	if !s.checkPassword(user, req.Password) {
+		return nil, s.loginFailed(loginKey, ipKey)
+	}
+	if err := s.limiter.Reset(loginKey); err != nil {
+		return nil, err
+	}
+	s.rehashPassword(user, req.Password)
+
+	return s.completeLogin(user, req.ClientInfo)
+}
+
+// completeLogin issues tokens for authenticated user, users
+// with TOTP get only mfa token for the second step
+func (s *UserServiceImpl) completeLogin(user *User, client ClientInfo) (*LoginUserResponse, error) {
+	if user.TOTP != nil && user.TOTP.Confirmed {
+		mfatoken, err := middleware.GenerateOpaqueToken()
+		if err != nil {
+			s.logger.Error("Error while creating mfa token", zap.Error(err))
+			return nil, common.ErrInternalError
+		}
+		err = s.repo.CreateMFAChallenge(&MFAChallenge{
+			UserID:    user.ID,
+			TokenHash: middleware.HashToken(mfatoken),
+			ExpiresAt: time.Now().Add(time.Minute * time.Duration(common.MFA_TOKEN_EXP_MINUTES)),
+		})
+		if err != nil {
+			s.logger.Error("Error while creating mfa challenge", zap.Error(err))
+			return nil, err
+		}
+		return &LoginUserResponse{MFARequired: true, MFAToken: mfatoken}, nil
+	}
+
+	// Role which requires MFA gives no permissions until TOTP is confirmed,
+	// client is told about it to prompt the user to enroll
+	enroll, err := s.repo.IsMFARequired(user.ID)
+	if err != nil {
+		s.logger.Error("Error while checking if mfa is required", zap.Error(err))
+		return nil, err
+	}
+
+	token, refresh, err := s.issueTokens(user.ID, user.TokenVersion, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginUserResponse{Token: token, RefreshToken: refresh, MFAEnroll: enroll}, nil
+}
+
+// checkPassword compares password with the user's hash, users
+// created by external provider have no hash and no password
+func (s *UserServiceImpl) checkPassword(user *User, password string) bool {
+	if user.Password == "" {
+		s.hasher.Verify(s.dummyHash, password)
+		return false
+	}
+	ok, err := s.hasher.Verify(user.Password, password)
+	if err != nil {
+		s.logger.Error("Error while comparing hash and password", zap.Uint("userid", user.ID), zap.Error(err))
+		return false
+	}
+	return ok
+}
+
+// rehashPassword replaces hash made with old algorithm or parameters with the
+// current one. Password is already checked, so failure is only logged
+func (s *UserServiceImpl) rehashPassword(user *User, password string) {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		s.logger.Error("Error while rehashing the password", zap.Error(err))
+		return
+	}
+	if err := s.repo.UpdatePassword(user.ID, hash); err != nil {
+		s.logger.Error("Error while saving rehashed password", zap.Error(err))
+		return
+	}
+	s.logger.Info("Password is rehashed", zap.Uint("userid", user.ID))
+}
+
+// loginFailed counts failed attempt and returns error for the client
+func (s *UserServiceImpl) loginFailed(loginKey string, ipKey string) error {
+	if err := s.limiter.Fail(loginKey, common.LOGIN_FREE_ATTEMPTS); err != nil {
+		return err
+	}
+	if err := s.limiter.Fail(ipKey, common.IP_FREE_ATTEMPTS); err != nil {
+		return err
+	}
+	return common.ErrInvalidLoginOrPassword
+}
+
+// VerifyMFA is the second step of login, it exchanges mfa token
+// and TOTP or recovery code for access and refresh tokens
+func (s *UserServiceImpl) VerifyMFA(req *VerifyMFARequest) (*LoginUserResponse, error) {
+	s.logger.Info("In VerifyMFA")
+
+	challenge, err := s.repo.GetMFAChallengeByTokenHash(middleware.HashToken(req.MFAToken))
+	if err != nil {
+		s.logger.Error("Error while getting mfa challenge", zap.Error(err))
+		if err == common.ErrNotFound {
+			return nil, common.ErrUnauthorized
+		}
+		return nil, err
+	}
+	if challenge.UsedAt != nil || time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= common.MFA_MAX_ATTEMPTS {
+		s.logger.Info("Mfa challenge is used, expired or has no attempts left")
+		return nil, common.ErrUnauthorized
+	}
+
+	err = s.checkSecondFactor(challenge.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		if err == common.ErrInvalidCode {
+			_ = s.repo.IncrementMFAChallengeAttempts(challenge.ID)
+		}
+		return nil, err
+	}
+
+	err = s.repo.UseMFAChallenge(challenge.ID)
+	if err != nil {
+		s.logger.Error("Error while using mfa challenge", zap.Error(err))
+		return nil, common.ErrUnauthorized
+	}
+
+	version, err := s.repo.GetTokenVersion(challenge.UserID)
+	if err != nil {
+		s.logger.Error("Error while getting token version", zap.Error(err))
+		return nil, err
+	}
+	token, refresh, err := s.issueTokens(challenge.UserID, version, req.ClientInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginUserResponse{Token: token, RefreshToken: refresh}, nil
+}
+
+// issueTokens starts new session family and returns access and refresh tokens
+func (s *UserServiceImpl) issueTokens(userid uint, version uint, client ClientInfo) (string, string, error) {
+	roles, err := s.repo.GetUserRoles(userid)
+	if err != nil {
+		s.logger.Error("Error while getting user roles", zap.Error(err))
+		return "", "", err
+	}
+	perms, err := s.GetUserPermissions(userid)
+	if err != nil {
+		return "", "", err
+	}
+
+	familyid, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Error while creating session family id", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+	refresh, session, err := s.newSession(userid, familyid, client)
+	if err != nil {
+		return "", "", err
+	}
+	session.StartedAt = session.LastSeenAt
+	err = s.repo.CreateSession(session)
+	if err != nil {
+		s.logger.Error("Error while creating session", zap.Error(err))
+		return "", "", err
+	}
+
+	token, err := middleware.GenerateJWT(userid, roles, perms, version, familyid)
+	if err != nil {
+		s.logger.Error("Error while creating jwt token", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+
+	return token, refresh, nil
+}
+
+func (s *UserServiceImpl) RefreshToken(req *RefreshTokenRequest) (string, string, error) {
+	s.logger.Info("In RefreshToken")
+
+	session, err := s.repo.GetSessionByTokenHash(middleware.HashToken(req.RefreshToken))
+	if err != nil {
+		s.logger.Error("Error while getting session by refresh token", zap.Error(err))
+		switch err {
+		case common.ErrNotFound:
+			return "", "", common.ErrUnauthorized
+		default:
+			return "", "", err
+		}
+	}
+
+	// Token was already rotated, so somebody holds stolen copy of it.
+	// We can't tell who is legit, so the whole family is revoked
+	if session.RevokedAt != nil {
+		s.logger.Warn("Refresh token reuse detected, revoking session family", zap.Uint("userid", session.UserID))
+		err = s.repo.RevokeSessionFamily(session.FamilyID)
+		if err != nil {
+			s.logger.Error("Error while revoking session family", zap.Error(err))
+			return "", "", err
 		}
+		return "", "", common.ErrUnauthorized
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		s.logger.Info("Refresh token expired")
+		return "", "", common.ErrUnauthorized
 	}
 
-	roles, err = s.repo.GetUserRoles(user.ID)
+	roles, err := s.repo.GetUserRoles(session.UserID)
 	if err != nil {
 		s.logger.Error("Error while getting user roles", zap.Error(err))
-		return "", err
+		return "", "", err
+	}
+	perms, err := s.GetUserPermissions(session.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	version, err := s.repo.GetTokenVersion(session.UserID)
+	if err != nil {
+		s.logger.Error("Error while getting token version", zap.Error(err))
+		if err == common.ErrNotFound {
+			return "", "", common.ErrUnauthorized
+		}
+		return "", "", err
 	}
 
-	// Compare sent pass with hash pass
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(pass))
+	newrefresh, newsession, err := s.newSession(session.UserID, session.FamilyID, req.ClientInfo)
+	if err != nil {
+		return "", "", err
+	}
+	newsession.StartedAt = session.StartedAt
+	err = s.repo.RotateSession(session, newsession)
 	if err != nil {
-		s.logger.Error("Error while comparing hash and password", zap.Error(err))
-		return "", ErrInvalidLoginOrPassword
+		s.logger.Error("Error while rotating session", zap.Error(err))
+		if err == common.ErrUnauthorized {
+			_ = s.repo.RevokeSessionFamily(session.FamilyID)
+		}
+		return "", "", err
 	}
-	token, err := middleware.GenerateJWT(user.ID, roles)
+
+	token, err := middleware.GenerateJWT(session.UserID, roles, perms, version, session.FamilyID)
 	if err != nil {
 		s.logger.Error("Error while creating jwt token", zap.Error(err))
-		return "", ErrInternalError
+		return "", "", common.ErrInternalError
+	}
+
+	return token, newrefresh, nil
+}
+
+func (s *UserServiceImpl) Logout(refresh string) error {
+	s.logger.Info("In Logout")
+
+	if refresh == "" {
+		return nil
+	}
+
+	session, err := s.repo.GetSessionByTokenHash(middleware.HashToken(refresh))
+	if err == common.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		s.logger.Error("Error while getting session by refresh token", zap.Error(err))
+		return err
+	}
+
+	// Revoking the family also makes access tokens of this session invalid
+	err = s.repo.RevokeSessionFamily(session.FamilyID)
+	if err != nil {
+		s.logger.Error("Error while revoking session family", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAllTokens makes every access and refresh token and every personal
+// access token of the user invalid
+func (s *UserServiceImpl) RevokeAllTokens(id uint) error {
+	s.logger.Info("In RevokeAllTokens")
+
+	err := s.repo.IncrementTokenVersion(id)
+	if err != nil {
+		s.logger.Error("Error while incrementing token version", zap.Error(err))
+		return err
+	}
+
+	err = s.repo.RevokeUserSessions(id)
+	if err != nil {
+		s.logger.Error("Error while revoking user sessions", zap.Error(err))
+		return err
+	}
+
+	err = s.repo.RevokeUserAPITokens(id)
+	if err != nil {
+		s.logger.Error("Error while revoking user api tokens", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// newSession creates not yet persisted session with fresh refresh token
+func (s *UserServiceImpl) newSession(userid uint, familyid string, client ClientInfo) (string, *Session, error) {
+	refresh, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Error while creating refresh token", zap.Error(err))
+		return "", nil, common.ErrInternalError
 	}
 
-	return token, nil
+	return refresh, &Session{
+		UserID:     userid,
+		FamilyID:   familyid,
+		TokenHash:  middleware.HashToken(refresh),
+		ExpiresAt:  time.Now().Add(time.Hour * time.Duration(common.REFRESH_TOKEN_EXP_HOURS)),
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		LastSeenAt: time.Now(),
+	}, nil
 }
 
-func (s *UserServiceImpl) DeleteUser(id uint) error {
+func (s *UserServiceImpl) DeleteUser(req *DeleteUserRequest) error {
 	s.logger.Info("In DeleteUser")
 
-	err := s.repo.DeleteUser(id)
+	err := s.policy.Enforce(req.Actor, req.ID, "", types.PermUserManage, "user:delete", req.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteUser(req.ID)
 	if err != nil {
 		s.logger.Error("Error while deleting a user", zap.Error(err))
 		return err
@@ -179,14 +645,885 @@ func (s *UserServiceImpl) GetOne(id uint) (*User, error) {
 	return user, nil
 }
 
-func (s *UserServiceImpl) GetAll() ([]*User, error) {
+// GetAll returns page of users, total count of users matching the
+// filters and cursor of the next page, which is nil on the last page
+func (s *UserServiceImpl) GetAll(req *GetAllUsersRequest) ([]*User, int64, *UserCursor, error) {
 	s.logger.Info("In GetAll")
 
-	users, err := s.repo.GetAll()
+	users, total, err := s.repo.GetAll(req)
 	if err != nil {
 		s.logger.Error("Error while getting all users", zap.Error(err))
+		return nil, 0, nil, err
+	}
+
+	// repo returns one extra user if there is next page
+	if len(users) <= req.Limit {
+		return users, total, nil, nil
+	}
+	users = users[:req.Limit]
+	last := users[len(users)-1]
+	next := &UserCursor{
+		Sort: req.Sort,
+		ID:   last.ID,
+	}
+	switch strings.TrimPrefix(req.Sort, "-") {
+	case "login":
+		next.Login = last.Login
+	case "createdat":
+		next.CreatedAt = last.CreatedAt
+	}
+	return users, total, next, nil
+}
+
+func (s *UserServiceImpl) GetRoles() ([]*types.RoleData, error) {
+	s.logger.Info("In GetRoles")
+
+	roles, err := s.repo.GetAllRoleData()
+	if err != nil {
+		s.logger.Error("Error while getting roles", zap.Error(err))
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GrantRole adds role to the user. Permissions are read on every request,
+// so the user gets them without new login
+func (s *UserServiceImpl) GrantRole(userid uint, roledataid uint) error {
+	s.logger.Info("In GrantRole")
+
+	if _, err := s.repo.GetOneById(userid); err != nil {
+		s.logger.Error("Error while getting user to grant role", zap.Error(err))
+		return err
+	}
+	if _, err := s.repo.GetRoleDataById(roledataid); err != nil {
+		s.logger.Error("Error while getting role to grant", zap.Error(err))
+		return err
+	}
+
+	err := s.repo.AddUserRole(userid, roledataid)
+	if err != nil {
+		s.logger.Error("Error while granting role", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) RevokeRole(userid uint, roledataid uint) error {
+	s.logger.Info("In RevokeRole")
+
+	err := s.repo.RemoveUserRole(userid, roledataid)
+	if err != nil {
+		s.logger.Error("Error while revoking role", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) GetUsersByRole(roledataid uint) ([]*User, error) {
+	s.logger.Info("In GetUsersByRole")
+
+	if _, err := s.repo.GetRoleDataById(roledataid); err != nil {
+		s.logger.Error("Error while getting role", zap.Error(err))
+		return nil, err
+	}
+
+	users, err := s.repo.GetUsersByRole(roledataid)
+	if err != nil {
+		s.logger.Error("Error while getting users by role", zap.Error(err))
 		return nil, err
 	}
 
 	return users, nil
 }
+
+// ForgotPassword sends reset token to every user with this email. It doesn't
+// tell whether such users exist, so emails can't be enumerated: the response
+// is the same and emails are sent in background. Every request is counted
+// per email and per ip, so it can't be used to flood mailboxes
+func (s *UserServiceImpl) ForgotPassword(req *ForgotPasswordRequest) error {
+	s.logger.Info("In ForgotPassword")
+
+	emailKey, ipKey := lockout.ResetEmailKey(req.Email), lockout.ResetIPKey(req.IP)
+	if err := s.limiter.Check(emailKey, ipKey); err != nil {
+		return err
+	}
+	if err := s.limiter.Fail(emailKey, common.RESET_FREE_REQUESTS); err != nil {
+		return err
+	}
+	if err := s.limiter.Fail(ipKey, common.RESET_IP_FREE_REQUESTS); err != nil {
+		return err
+	}
+
+	// Reset token is sent only to verified email, otherwise the account could be
+	// taken over by setting own email without knowing the password
+	users, err := s.repo.GetVerifiedUsersByEmail(req.Email)
+	if err != nil {
+		s.logger.Error("Error while getting users by email", zap.Error(err))
+		return err
+	}
+
+	for _, user := range users {
+		token, err := middleware.GenerateOpaqueToken()
+		if err != nil {
+			s.logger.Error("Error while creating reset token", zap.Error(err))
+			continue
+		}
+
+		err = s.repo.CreatePasswordReset(&PasswordReset{
+			UserID:    user.ID,
+			TokenHash: middleware.HashToken(token),
+			ExpiresAt: time.Now().Add(time.Minute * time.Duration(common.RESET_TOKEN_EXP_MINUTES)),
+		})
+		if err != nil {
+			s.logger.Error("Error while creating password reset", zap.Error(err))
+			continue
+		}
+
+		body := fmt.Sprintf("Somebody requested password reset for the account %s.\n\n"+
+			"Use this token to set new password, it is valid for %d minutes:\n%s\n\n"+
+			"If it wasn't you, just ignore this email.", user.Login, common.RESET_TOKEN_EXP_MINUTES, token)
+		s.sendMailAsync(req.Email, "Password reset", body)
+	}
+
+	return nil
+}
+
+// sendMailAsync sends email in background, so response time and errors
+// of the mailer don't tell whether email was sent
+func (s *UserServiceImpl) sendMailAsync(to string, subject string, body string) {
+	go func() {
+		if err := s.mailer.Send(to, subject, body); err != nil {
+			s.logger.Error("Error while sending email", zap.String("subject", subject), zap.Error(err))
+		}
+	}()
+}
+
+// ChangePassword sets new password after checking the current one. All
+// other sessions and all personal access tokens of the user are revoked,
+// because they could be created by anybody who knew the old password.
+// The current session stays logged in
+func (s *UserServiceImpl) ChangePassword(req *ChangePasswordRequest) error {
+	s.logger.Info("In ChangePassword")
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return common.ErrBadRequest
+	}
+
+	user, err := s.repo.GetOneById(req.ID)
+	if err != nil {
+		s.logger.Error("Error while getting user", zap.Error(err))
+		return err
+	}
+
+	// Current password can be guessed with stolen session as well as with login
+	loginKey := lockout.LoginKey(user.Login)
+	if err := s.limiter.Check(loginKey); err != nil {
+		return err
+	}
+	if !s.checkPassword(user, req.CurrentPassword) {
+		s.logger.Info("Current password doesn't match")
+		if err := s.limiter.Fail(loginKey, common.LOGIN_FREE_ATTEMPTS); err != nil {
+			return err
+		}
+		return common.ErrInvalidLoginOrPassword
+	}
+	if err := s.limiter.Reset(loginKey); err != nil {
+		return err
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		return common.ErrWeakPassword
+	}
+	if err := s.passwords.Check(req.NewPassword, user.Login); err != nil {
+		s.logger.Info("Password doesn't follow password policy")
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		s.logger.Error("Error while hashing the password", zap.Error(err))
+		return common.ErrInternalError
+	}
+	err = s.repo.UpdatePassword(user.ID, hash)
+	if err != nil {
+		s.logger.Error("Error while updating password", zap.Error(err))
+		return err
+	}
+
+	err = s.repo.InvalidatePasswordResets(user.ID)
+	if err != nil {
+		s.logger.Error("Error while invalidating password resets", zap.Error(err))
+		return err
+	}
+	err = s.repo.RevokeUserSessionsExcept(user.ID, req.CurrentSID)
+	if err != nil {
+		s.logger.Error("Error while revoking other sessions", zap.Error(err))
+		return err
+	}
+	err = s.repo.RevokeUserAPITokens(user.ID)
+	if err != nil {
+		s.logger.Error("Error while revoking api tokens", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) ResetPassword(req *ResetPasswordRequest) error {
+	s.logger.Info("In ResetPassword")
+
+	if req.Password == "" {
+		return common.ErrBadRequest
+	}
+
+	reset, err := s.repo.GetPasswordResetByTokenHash(middleware.HashToken(req.Token))
+	if err != nil {
+		s.logger.Error("Error while getting password reset", zap.Error(err))
+		if err == common.ErrNotFound {
+			return common.ErrInvalidToken
+		}
+		return err
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		s.logger.Info("Reset token is used or expired")
+		return common.ErrInvalidToken
+	}
+
+	user, err := s.repo.GetOneById(reset.UserID)
+	if err != nil {
+		s.logger.Error("Error while getting user", zap.Error(err))
+		return err
+	}
+	// Token is kept for another try if password is rejected
+	if err := s.passwords.Check(req.Password, user.Login); err != nil {
+		s.logger.Info("Password doesn't follow password policy")
+		return err
+	}
+
+	err = s.repo.UsePasswordReset(reset.ID)
+	if err != nil {
+		s.logger.Error("Error while using password reset", zap.Error(err))
+		return err
+	}
+
+	hash, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		s.logger.Error("Error while hashing the password", zap.Error(err))
+		return common.ErrInternalError
+	}
+
+	err = s.repo.UpdatePassword(reset.UserID, hash)
+	if err != nil {
+		s.logger.Error("Error while updating password", zap.Error(err))
+		return err
+	}
+
+	// Other reset tokens and all sessions were issued for the old password
+	err = s.repo.InvalidatePasswordResets(reset.UserID)
+	if err != nil {
+		s.logger.Error("Error while invalidating password resets", zap.Error(err))
+		return err
+	}
+	err = s.RevokeAllTokens(reset.UserID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUserPermissions returns permissions the user has right now, it is
+// used both for new tokens and on every authorized request
+func (s *UserServiceImpl) GetUserPermissions(id uint) ([]types.Permission, error) {
+	perms, err := s.repo.GetUserPermissions(id)
+	if err != nil {
+		s.logger.Error("Error while getting user permissions", zap.Error(err))
+		return nil, err
+	}
+
+	if !common.REQUIRE_VERIFIED_EMAIL {
+		return perms, nil
+	}
+	verified, err := s.repo.IsEmailVerified(id)
+	if err != nil {
+		s.logger.Error("Error while checking email verified", zap.Error(err))
+		return nil, err
+	}
+	if verified {
+		return perms, nil
+	}
+
+	allowed := make([]types.Permission, 0, len(perms))
+	for _, perm := range perms {
+		if !slices.Contains(types.VerifiedEmailPermissions, perm) {
+			allowed = append(allowed, perm)
+		}
+	}
+	return allowed, nil
+}
+
+func (s *UserServiceImpl) VerifyEmail(token string) error {
+	s.logger.Info("In VerifyEmail")
+
+	verification, err := s.repo.GetVerificationByTokenHash(middleware.HashToken(token))
+	if err != nil {
+		s.logger.Error("Error while getting verification", zap.Error(err))
+		if err == common.ErrNotFound {
+			return common.ErrInvalidToken
+		}
+		return err
+	}
+	if verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+		s.logger.Info("Verification token is used or expired")
+		return common.ErrInvalidToken
+	}
+
+	err = s.repo.UseVerification(verification.ID)
+	if err != nil {
+		s.logger.Error("Error while using verification", zap.Error(err))
+		return err
+	}
+
+	err = s.repo.SetEmailVerified(verification.UserID, verification.Email)
+	if err != nil {
+		s.logger.Error("Error while setting email verified", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) ResendVerification(id uint) error {
+	s.logger.Info("In ResendVerification")
+
+	user, err := s.repo.GetOneById(id)
+	if err != nil {
+		s.logger.Error("Error while getting user", zap.Error(err))
+		return err
+	}
+	if user.ContactInfo == nil || user.ContactInfo.Email == "" {
+		return common.ErrBadRequest
+	}
+	if user.ContactInfo.EmailVerified {
+		return common.ErrAlreadyExists
+	}
+
+	err = s.sendVerification(user.ID, user.Login, user.ContactInfo.Email)
+	if err != nil {
+		s.logger.Error("Error while sending verification email", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) sendVerification(userid uint, login string, email string) error {
+	token, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		return common.ErrInternalError
+	}
+
+	err = s.repo.CreateVerification(&Verification{
+		UserID:    userid,
+		Email:     email,
+		TokenHash: middleware.HashToken(token),
+		ExpiresAt: time.Now().Add(time.Hour * time.Duration(common.VERIFY_TOKEN_EXP_HOURS)),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Please confirm that %s is the email of the account %s.\n\n"+
+		"Use this token to verify it, it is valid for %d hours:\n%s", email, login, common.VERIFY_TOKEN_EXP_HOURS, token)
+	err = s.mailer.Send(email, "Email verification", body)
+	if err != nil {
+		return common.ErrInternalError
+	}
+
+	return nil
+}
+
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// EnrollTOTP creates not confirmed TOTP secret and returns
+// it together with otpauth uri for authenticator apps
+func (s *UserServiceImpl) EnrollTOTP(id uint) (string, string, error) {
+	s.logger.Info("In EnrollTOTP")
+
+	user, err := s.repo.GetOneById(id)
+	if err != nil {
+		s.logger.Error("Error while getting user", zap.Error(err))
+		return "", "", err
+	}
+	if user.TOTP != nil && user.TOTP.Confirmed {
+		s.logger.Info("Totp is already enabled")
+		return "", "", common.ErrAlreadyExists
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		s.logger.Error("Error while generating totp secret", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+	err = s.repo.CreateTOTP(&TOTP{UserID: id, Secret: secret})
+	if err != nil {
+		s.logger.Error("Error while creating totp", zap.Error(err))
+		return "", "", err
+	}
+
+	return secret, totpURI(common.TOTP_ISSUER, user.Login, secret), nil
+}
+
+// ConfirmTOTP enables TOTP and returns recovery codes, they are shown only once
+func (s *UserServiceImpl) ConfirmTOTP(id uint, code string) ([]string, error) {
+	s.logger.Info("In ConfirmTOTP")
+
+	totp, err := s.repo.GetTOTP(id)
+	if err != nil {
+		s.logger.Error("Error while getting totp", zap.Error(err))
+		if err == common.ErrNotFound {
+			return nil, common.ErrBadRequest
+		}
+		return nil, err
+	}
+	if totp.Confirmed {
+		return nil, common.ErrAlreadyExists
+	}
+
+	step, ok := validateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return nil, common.ErrInvalidCode
+	}
+	err = s.repo.UseTOTPStep(totp.ID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, common.RECOVERY_CODES_COUNT)
+	hashes := make([]string, 0, common.RECOVERY_CODES_COUNT)
+	for i := 0; i < common.RECOVERY_CODES_COUNT; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			s.logger.Error("Error while generating recovery code", zap.Error(err))
+			return nil, common.ErrInternalError
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, middleware.HashToken(code))
+	}
+	err = s.repo.ReplaceRecoveryCodes(id, hashes)
+	if err != nil {
+		s.logger.Error("Error while saving recovery codes", zap.Error(err))
+		return nil, err
+	}
+
+	err = s.repo.ConfirmTOTP(totp.ID)
+	if err != nil {
+		s.logger.Error("Error while confirming totp", zap.Error(err))
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *UserServiceImpl) DisableTOTP(id uint, code string, recovery string) error {
+	s.logger.Info("In DisableTOTP")
+
+	required, err := s.repo.IsMFARequired(id)
+	if err != nil {
+		s.logger.Error("Error while checking if mfa is required", zap.Error(err))
+		return err
+	}
+	if required {
+		s.logger.Info("Totp can't be disabled, it is required by user's role")
+		return common.ErrForbidden
+	}
+
+	err = s.checkSecondFactor(id, code, recovery)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.DeleteTOTP(id)
+	if err != nil {
+		s.logger.Error("Error while deleting totp", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) SetRoleRequireMFA(roledataid uint, required bool) error {
+	s.logger.Info("In SetRoleRequireMFA")
+
+	err := s.repo.SetRoleRequireMFA(roledataid, required)
+	if err != nil {
+		s.logger.Error("Error while setting role require mfa", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// checkSecondFactor accepts either TOTP code or recovery code of the user
+func (s *UserServiceImpl) checkSecondFactor(userid uint, code string, recovery string) error {
+	if recovery != "" {
+		return s.repo.UseRecoveryCode(userid, middleware.HashToken(strings.ToLower(strings.TrimSpace(recovery))))
+	}
+	if code == "" {
+		return common.ErrBadRequest
+	}
+
+	totp, err := s.repo.GetTOTP(userid)
+	if err != nil {
+		s.logger.Error("Error while getting totp", zap.Error(err))
+		if err == common.ErrNotFound {
+			return common.ErrBadRequest
+		}
+		return err
+	}
+	if !totp.Confirmed {
+		return common.ErrBadRequest
+	}
+
+	step, ok := validateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return common.ErrInvalidCode
+	}
+	return s.repo.UseTOTPStep(totp.ID, step)
+}
+
+// OIDCLogin starts login with external provider, it returns url of the
+// provider's login page and state which must come back to the callback
+func (s *UserServiceImpl) OIDCLogin(provider string) (string, string, error) {
+	s.logger.Info("In OIDCLogin")
+
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", common.ErrNotFound
+	}
+
+	state, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Error while creating oidc state", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+	nonce, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Error while creating oidc nonce", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+	verifier, challenge, err := oidc.GenerateVerifier()
+	if err != nil {
+		s.logger.Error("Error while creating pkce verifier", zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+
+	url, err := p.AuthCodeURL(state, nonce, challenge)
+	if err != nil {
+		s.logger.Error("Error while building provider login url", zap.String("provider", provider), zap.Error(err))
+		return "", "", common.ErrInternalError
+	}
+
+	err = s.repo.CreateOIDCState(&OIDCState{
+		StateHash: middleware.HashToken(state),
+		Provider:  provider,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(time.Minute * time.Duration(common.OIDC_STATE_EXP_MINUTES)),
+	})
+	if err != nil {
+		s.logger.Error("Error while saving oidc state", zap.Error(err))
+		return "", "", err
+	}
+
+	return url, state, nil
+}
+
+// OIDCCallback exchanges authorization code for id token and logs in the user
+// linked to the external identity. Identity is linked to existing user only
+// by email verified on both sides, otherwise new user is created
+func (s *UserServiceImpl) OIDCCallback(req *OIDCCallbackRequest) (*LoginUserResponse, error) {
+	s.logger.Info("In OIDCCallback")
+
+	p, ok := s.providers[req.Provider]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+
+	state, err := s.repo.GetOIDCStateByHash(middleware.HashToken(req.State))
+	if err != nil {
+		s.logger.Error("Error while getting oidc state", zap.Error(err))
+		if err == common.ErrNotFound {
+			return nil, common.ErrUnauthorized
+		}
+		return nil, err
+	}
+	if state.UsedAt != nil || time.Now().After(state.ExpiresAt) || state.Provider != req.Provider {
+		s.logger.Info("Oidc state is used, expired or belongs to another provider")
+		return nil, common.ErrUnauthorized
+	}
+	if err := s.repo.UseOIDCState(state.ID); err != nil {
+		return nil, common.ErrUnauthorized
+	}
+
+	claims, err := p.Exchange(req.Code, state.Verifier, state.Nonce)
+	if err != nil {
+		s.logger.Error("Error while exchanging authorization code", zap.String("provider", req.Provider), zap.Error(err))
+		return nil, common.ErrUnauthorized
+	}
+
+	userid, err := s.findOrCreateOIDCUser(req.Provider, claims)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.GetOneById(userid)
+	if err != nil {
+		s.logger.Error("Error while getting user", zap.Error(err))
+		return nil, err
+	}
+
+	return s.completeLogin(user, req.ClientInfo)
+}
+
+func (s *UserServiceImpl) findOrCreateOIDCUser(provider string, claims *oidc.Claims) (uint, error) {
+	identity, err := s.repo.GetIdentity(provider, claims.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+	if err != common.ErrNotFound {
+		s.logger.Error("Error while getting identity", zap.Error(err))
+		return 0, err
+	}
+
+	link := Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	if claims.EmailVerified && claims.Email != "" {
+		users, err := s.repo.GetVerifiedUsersByEmail(claims.Email)
+		if err != nil {
+			s.logger.Error("Error while getting users by email", zap.Error(err))
+			return 0, err
+		}
+		for _, val := range users {
+			if val.ContactInfo != nil && val.ContactInfo.EmailVerified {
+				link.UserID = val.ID
+				if err := s.repo.CreateIdentity(&link); err != nil {
+					return 0, err
+				}
+				s.logger.Info("External identity is linked to existing user", zap.String("provider", provider), zap.Uint("userid", val.ID))
+				return val.ID, nil
+			}
+		}
+	}
+
+	login, err := s.freeLogin(provider, claims)
+	if err != nil {
+		return 0, err
+	}
+	roledata, err := s.repo.GetRoleDataByName(types.RoleCommon)
+	if err != nil {
+		s.logger.Error("Error while getting default role", zap.Error(err))
+		return 0, common.ErrInternalError
+	}
+
+	// Password is empty, so the user can't log in with password
+	// until it is set with password reset
+	user := User{
+		Login:       login,
+		ContactInfo: &ContactInfo{},
+		PersonalInfo: &PersonalInfo{
+			FirstName: claims.Name,
+			Location:  &Location{},
+		},
+		Role: []types.Role{
+			{RoleDataID: roledata.ID},
+		},
+		Identity: []Identity{link},
+	}
+	if claims.EmailVerified && isValidEmail(claims.Email) {
+		user.ContactInfo.Email = claims.Email
+		user.ContactInfo.EmailVerified = true
+	}
+
+	id, err := s.repo.CreateUser(&user)
+	if err != nil {
+		s.logger.Error("Error while creating a user", zap.Error(err))
+		return 0, err
+	}
+	s.logger.Info("User is created from external identity", zap.String("provider", provider), zap.Uint("userid", id))
+
+	return id, nil
+}
+
+// freeLogin picks login for new user from the provider's claims
+func (s *UserServiceImpl) freeLogin(provider string, claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if base == "" {
+		base = provider
+	}
+
+	login := base
+	for i := 0; i < 5; i++ {
+		_, err := s.repo.GetOneByLogin(login)
+		if err == common.ErrNotFound {
+			return login, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		suffix, err := middleware.GenerateOpaqueToken()
+		if err != nil {
+			return "", common.ErrInternalError
+		}
+		login = base + "-" + suffix[:6]
+	}
+	return "", common.ErrAlreadyExists
+}
+
+// CreateAPIToken creates personal access token, scopes must be
+// permissions which the user has. Token is returned only once
+func (s *UserServiceImpl) CreateAPIToken(req *CreateAPITokenRequest) (string, *APIToken, error) {
+	s.logger.Info("In CreateAPIToken")
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 || len(req.Scopes) == 0 {
+		return "", nil, common.ErrBadRequest
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = common.API_TOKEN_EXP_DAYS
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > common.API_TOKEN_MAX_EXP_DAYS {
+		return "", nil, common.ErrBadRequest
+	}
+
+	perms, err := s.GetUserPermissions(req.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	scopes := make([]types.Permission, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(perms, scope) {
+			s.logger.Info("User has no permission for requested scope", zap.String("scope", string(scope)))
+			return "", nil, common.ErrForbidden
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		s.logger.Error("Error while creating api token", zap.Error(err))
+		return "", nil, common.ErrInternalError
+	}
+	token := common.API_TOKEN_PREFIX + secret
+
+	apitoken := APIToken{
+		UserID:    req.UserID,
+		Name:      req.Name,
+		TokenHash: middleware.HashToken(token),
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(time.Hour * 24 * time.Duration(req.ExpiresInDays)),
+	}
+	err = s.repo.CreateAPIToken(&apitoken)
+	if err != nil {
+		s.logger.Error("Error while saving api token", zap.Error(err))
+		return "", nil, err
+	}
+
+	return token, &apitoken, nil
+}
+
+func (s *UserServiceImpl) GetAPITokens(userid uint) ([]*APIToken, error) {
+	s.logger.Info("In GetAPITokens")
+
+	tokens, err := s.repo.GetAPITokens(userid)
+	if err != nil {
+		s.logger.Error("Error while getting api tokens", zap.Error(err))
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *UserServiceImpl) RevokeAPIToken(userid uint, id uint) error {
+	s.logger.Info("In RevokeAPIToken")
+
+	err := s.repo.RevokeAPIToken(userid, id)
+	if err != nil {
+		s.logger.Error("Error while revoking api token", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// AuthenticateAPIToken returns owner, id and scopes of valid personal access token
+func (s *UserServiceImpl) AuthenticateAPIToken(hash string) (uint, uint, []types.Permission, error) {
+	token, err := s.repo.GetAPITokenByHash(hash)
+	if err != nil {
+		if err == common.ErrNotFound {
+			return 0, 0, nil, common.ErrUnauthorized
+		}
+		return 0, 0, nil, err
+	}
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		return 0, 0, nil, common.ErrUnauthorized
+	}
+
+	// Last use is only informational, so failed update doesn't fail the request
+	_ = s.repo.TouchAPIToken(token.ID)
+
+	return token.UserID, token.ID, token.Scopes, nil
+}
+
+// GetSessions returns active sessions of the user, one for every login
+func (s *UserServiceImpl) GetSessions(userid uint) ([]*Session, error) {
+	s.logger.Info("In GetSessions")
+
+	sessions, err := s.repo.GetActiveSessions(userid)
+	if err != nil {
+		s.logger.Error("Error while getting sessions", zap.Error(err))
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes session family sid of the user,
+// its access tokens stop working on the next request
+func (s *UserServiceImpl) RevokeSession(userid uint, sid string) error {
+	s.logger.Info("In RevokeSession")
+
+	err := s.repo.RevokeUserSessionFamily(userid, sid)
+	if err != nil {
+		s.logger.Error("Error while revoking session", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserServiceImpl) RevokeOtherSessions(userid uint, currentsid string) error {
+	s.logger.Info("In RevokeOtherSessions")
+
+	err := s.repo.RevokeUserSessionsExcept(userid, currentsid)
+	if err != nil {
+		s.logger.Error("Error while revoking sessions", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package user
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/lockout"
+	"github.com/maxik12233/blog/mailer"
+	"github.com/maxik12233/blog/middleware"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMain(m *testing.M) {
+	// Ephemeral key signs access tokens issued by tests
+	if err := middleware.LoadKeys(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestService(t *testing.T, repo UserRepository) (*UserServiceImpl, *mailer.MemoryMailer) {
+	t.Helper()
+
+	passwords, err := LoadPasswordPolicy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := NewPasswordHasher("bcrypt", bcrypt.MinCost, testArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.NewNop()
+	outbox := mailer.NewMemoryMailer(logger)
+	limiter := lockout.NewLimiter(lockout.NewMemoryStore(), logger)
+
+	s := NewUserService(repo, nil, outbox, limiter, nil, passwords, hasher, logger)
+	return s.(*UserServiceImpl), outbox
+}
+
+// testArgon2id is cheap enough to keep tests fast
+var testArgon2id = Argon2idHasher{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func hashPassword(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// waitForMail polls outbox because emails are sent in background
+func waitForMail(t *testing.T, outbox *mailer.MemoryMailer, count int) []mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		messages := outbox.Outbox()
+		if len(messages) >= count || time.Now().After(deadline) {
+			if len(messages) != count {
+				t.Fatalf("outbox has %d messages, want %d", len(messages), count)
+			}
+			return messages
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// resetTokenFromBody returns the line after "valid for N minutes:"
+func resetTokenFromBody(t *testing.T, body string) string {
+	t.Helper()
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.HasSuffix(line, "minutes:") && i+1 < len(lines) {
+			return lines[i+1]
+		}
+	}
+	t.Fatalf("no reset token in email body %q", body)
+	return ""
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	repo := newMemRepo(
+		&User{ID: 1, Login: "alice", Password: hashPassword(t, "old password"),
+			ContactInfo: &ContactInfo{Email: "alice@example.com", EmailVerified: true}},
+		&User{ID: 2, Login: "mallory", Password: hashPassword(t, "other password"),
+			ContactInfo: &ContactInfo{Email: "bob@example.com", EmailVerified: false}},
+	)
+	s, outbox := newTestService(t, repo)
+
+	// Unverified email gets the same response, but no reset is created
+	if err := s.ForgotPassword(&ForgotPasswordRequest{Email: "bob@example.com", IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("forgot for unverified email: %v", err)
+	}
+	if len(repo.resets) != 0 {
+		t.Fatalf("reset was created for unverified email")
+	}
+
+	if err := s.ForgotPassword(&ForgotPasswordRequest{Email: "alice@example.com", IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("forgot: %v", err)
+	}
+	msg := waitForMail(t, outbox, 1)[0]
+	if msg.To != "alice@example.com" || msg.Subject != "Password reset" {
+		t.Fatalf("unexpected email %+v", msg)
+	}
+	token := resetTokenFromBody(t, msg.Body)
+
+	if err := s.ResetPassword(&ResetPasswordRequest{Token: token, Password: "new password"}); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	user, _ := repo.GetOneById(1)
+	if ok, _ := s.hasher.Verify(user.Password, "new password"); !ok {
+		t.Fatalf("password was not changed")
+	}
+	if user.TokenVersion != 2 {
+		t.Fatalf("token version is %d, issued tokens were not revoked", user.TokenVersion)
+	}
+
+	// Token is single-use
+	err := s.ResetPassword(&ResetPasswordRequest{Token: token, Password: "another password"})
+	if err != common.ErrInvalidToken {
+		t.Fatalf("reused token: got %v, want ErrInvalidToken", err)
+	}
+	user, _ = repo.GetOneById(1)
+	if ok, _ := s.hasher.Verify(user.Password, "new password"); !ok {
+		t.Fatalf("password was changed with reused token")
+	}
+}
+
+func TestResetPasswordRejectsExpiredToken(t *testing.T) {
+	repo := newMemRepo(&User{ID: 1, Login: "alice", Password: hashPassword(t, "old password")})
+	s, _ := newTestService(t, repo)
+
+	token, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = repo.CreatePasswordReset(&PasswordReset{
+		UserID:    1,
+		TokenHash: middleware.HashToken(token),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	err = s.ResetPassword(&ResetPasswordRequest{Token: token, Password: "new password"})
+	if err != common.ErrInvalidToken {
+		t.Fatalf("expired token: got %v, want ErrInvalidToken", err)
+	}
+	err = s.ResetPassword(&ResetPasswordRequest{Token: "unknown", Password: "new password"})
+	if err != common.ErrInvalidToken {
+		t.Fatalf("unknown token: got %v, want ErrInvalidToken", err)
+	}
+	user, _ := repo.GetOneById(1)
+	if ok, _ := s.hasher.Verify(user.Password, "old password"); !ok {
+		t.Fatalf("password was changed with invalid token")
+	}
+}
+
+func TestResetPasswordRejectsLogin(t *testing.T) {
+	repo := newMemRepo(&User{ID: 1, Login: "alice-in-wonderland", Password: hashPassword(t, "old password")})
+	s, _ := newTestService(t, repo)
+
+	token, err := middleware.GenerateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = repo.CreatePasswordReset(&PasswordReset{
+		UserID:    1,
+		TokenHash: middleware.HashToken(token),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+
+	err = s.ResetPassword(&ResetPasswordRequest{Token: token, Password: "Alice-In-Wonderland"})
+	if err != common.ErrWeakPassword {
+		t.Fatalf("login as password: got %v, want ErrWeakPassword", err)
+	}
+	// Rejected password doesn't use the token
+	if err := s.ResetPassword(&ResetPasswordRequest{Token: token, Password: "new password"}); err != nil {
+		t.Fatalf("reset after rejected password: %v", err)
+	}
+}
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	repo := newMemRepo()
+	s, _ := newTestService(t, repo)
+
+	req := &ForgotPasswordRequest{Email: "alice@example.com", IP: "10.0.0.1"}
+	for i := 0; i <= common.RESET_FREE_REQUESTS; i++ {
+		if err := s.ForgotPassword(req); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	err := s.ForgotPassword(&ForgotPasswordRequest{Email: "ALICE@example.com", IP: "10.0.0.2"})
+	if !errors.Is(err, common.ErrTooManyAttempts) {
+		t.Fatalf("got %v, want ErrTooManyAttempts", err)
+	}
+}
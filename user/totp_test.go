@@ -0,0 +1,218 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maxik12233/blog/common"
+	"github.com/maxik12233/blog/middleware"
+)
+
+// rfc6238Secret is ASCII "12345678901234567890", the SHA1 key of RFC 6238 appendix B
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 vectors are 8 digits long, 6 digit codes are their last digits
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.time, 0)))
+		if err != nil {
+			t.Fatalf("time %d: %v", tt.time, err)
+		}
+		if got != tt.code {
+			t.Errorf("time %d: got %s, want %s", tt.time, got, tt.code)
+		}
+	}
+
+	lower, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || lower != "287082" {
+		t.Errorf("lowercase secret: got %s, %v", lower, err)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("malformed secret was accepted")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	code := func(step int64) string {
+		code, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		valid  bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: code(step), step: step, valid: true},
+		{name: "previous step", secret: rfc6238Secret, code: code(step - 1), step: step - 1, valid: true},
+		{name: "next step", secret: rfc6238Secret, code: code(step + 1), step: step + 1, valid: true},
+		{name: "two steps ago", secret: rfc6238Secret, code: code(step - 2)},
+		{name: "two steps ahead", secret: rfc6238Secret, code: code(step + 2)},
+		{name: "wrong code", secret: rfc6238Secret, code: "000000"},
+		{name: "empty code", secret: rfc6238Secret, code: ""},
+		{name: "malformed secret", secret: "not base32!", code: code(step)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := validateTOTP(tt.secret, tt.code, now)
+			if ok != tt.valid {
+				t.Fatalf("got valid %v, want %v", ok, tt.valid)
+			}
+			if ok && got != tt.step {
+				t.Fatalf("got step %d, want %d", got, tt.step)
+			}
+		})
+	}
+}
+
+// newMFAUser returns repo with user alice who has confirmed TOTP and recovery codes
+func newMFAUser(t *testing.T, recovery ...string) (*memRepo, string) {
+	t.Helper()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp := &TOTP{ID: 1, UserID: 1, Secret: secret, Confirmed: true}
+	repo := newMemRepo(&User{ID: 1, Login: "alice", Password: hashPassword(t, "password"), TOTP: totp})
+	repo.totps[1] = totp
+	for i, code := range recovery {
+		repo.recovery = append(repo.recovery, &RecoveryCode{ID: uint(i + 1), UserID: 1, CodeHash: middleware.HashToken(code)})
+	}
+	return repo, secret
+}
+
+// mfaToken logs in with password and returns token of the second step
+func mfaToken(t *testing.T, s *UserServiceImpl) string {
+	t.Helper()
+	resp, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !resp.MFARequired || resp.MFAToken == "" || resp.Token != "" {
+		t.Fatalf("login of user with TOTP returned %+v", resp)
+	}
+	return resp.MFAToken
+}
+
+func TestVerifyMFARejectsReusedCode(t *testing.T) {
+	repo, secret := newMFAUser(t)
+	s, _ := newTestService(t, repo)
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := mfaToken(t, s)
+	resp, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: token, Code: code})
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if resp.Token == "" || resp.RefreshToken == "" {
+		t.Fatalf("no tokens in %+v", resp)
+	}
+
+	// Challenge is single-use
+	if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: token, Code: code}); err != common.ErrUnauthorized {
+		t.Fatalf("reused challenge: got %v, want ErrUnauthorized", err)
+	}
+
+	// The same code is rejected in the new login, even within its step
+	token = mfaToken(t, s)
+	if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: token, Code: code}); err != common.ErrInvalidCode {
+		t.Fatalf("reused code: got %v, want ErrInvalidCode", err)
+	}
+	challenge, _ := repo.GetMFAChallengeByTokenHash(middleware.HashToken(token))
+	if challenge.Attempts != 1 || challenge.UsedAt != nil {
+		t.Fatalf("failed attempt is not counted: %+v", challenge)
+	}
+}
+
+func TestVerifyMFARecoveryCodeIsSingleUse(t *testing.T) {
+	repo, _ := newMFAUser(t, "abcdefgh-ijklmnop", "qrstuvwx-yz234567")
+	s, _ := newTestService(t, repo)
+
+	// Code is accepted regardless of case and surrounding spaces
+	resp, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: mfaToken(t, s), RecoveryCode: " ABCDEFGH-ijklmnop "})
+	if err != nil {
+		t.Fatalf("verify with recovery code: %v", err)
+	}
+	if resp.Token == "" {
+		t.Fatalf("no tokens in %+v", resp)
+	}
+
+	_, err = s.VerifyMFA(&VerifyMFARequest{MFAToken: mfaToken(t, s), RecoveryCode: "abcdefgh-ijklmnop"})
+	if err != common.ErrInvalidCode {
+		t.Fatalf("reused recovery code: got %v, want ErrInvalidCode", err)
+	}
+
+	// Other codes are still valid
+	if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: mfaToken(t, s), RecoveryCode: "qrstuvwx-yz234567"}); err != nil {
+		t.Fatalf("verify with other recovery code: %v", err)
+	}
+}
+
+func TestVerifyMFAChallengeAttemptsAreLimited(t *testing.T) {
+	repo, secret := newMFAUser(t)
+	s, _ := newTestService(t, repo)
+
+	token := mfaToken(t, s)
+	for i := 0; i < common.MFA_MAX_ATTEMPTS; i++ {
+		if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: token, Code: "000000"}); err != common.ErrInvalidCode {
+			t.Fatalf("attempt %d: got %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.VerifyMFA(&VerifyMFARequest{MFAToken: token, Code: code}); err != common.ErrUnauthorized {
+		t.Fatalf("valid code after too many attempts: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestLoginTellsToEnrollMFA(t *testing.T) {
+	repo := newMemRepo(
+		&User{ID: 1, Login: "alice", Password: hashPassword(t, "password")},
+		&User{ID: 2, Login: "bob", Password: hashPassword(t, "password")},
+	)
+	repo.requireMFA[1] = true
+	s, _ := newTestService(t, repo)
+
+	resp, err := s.LoginUser(&LoginUserRequest{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !resp.MFAEnroll || resp.Token == "" {
+		t.Fatalf("user whose role requires MFA got %+v", resp)
+	}
+
+	resp, err = s.LoginUser(&LoginUserRequest{Login: "bob", Password: "password"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if resp.MFAEnroll {
+		t.Fatalf("user without such role is told to enroll")
+	}
+}
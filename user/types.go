@@ -1,6 +1,8 @@
 package user
 
 import (
+	"time"
+
 	"github.com/maxik12233/blog/types"
 )
 
@@ -9,31 +11,36 @@ import (
 
 type Location struct {
 	ID      uint   `gorm:"primaryKey"`
-	Country string `gorm:"default:" json:"country"`
-	City    string `gorm:"default:" json:"city"`
+	Country string `gorm:"default:" json:"country" validate:"max=100"`
+	City    string `gorm:"default:" json:"city" validate:"max=100"`
 }
 
 type ContactInfo struct {
-	ID     uint   `gorm:"primaryKey"`
-	Mobile string `gorm:"default:"`
-	Email  string `gorm:"default:" json:"email"`
+	ID            uint   `gorm:"primaryKey"`
+	Mobile        string `gorm:"default:" validate:"max=32"`
+	Email         string `gorm:"default:" json:"email" validate:"omitempty,email,max=254"`
+	EmailVerified bool   `gorm:"not null;default:false" json:"emailverified"`
+	Version       uint   `gorm:"not null;default:1" json:"version"` // incremented on every update
 }
 
 type PersonalInfo struct {
 	ID             uint   `gorm:"primaryKey"`
-	FirstName      string `gorm:"default:" json:"name"`
-	LastName       string `gorm:"default:" json:"surname"`
-	PersonalStatus string `gorm:"default:" json:"status"`
-	Description    string `gorm:"default:" json:"descr"`
+	FirstName      string `gorm:"default:" json:"name" validate:"max=100"`
+	LastName       string `gorm:"default:" json:"surname" validate:"max=100"`
+	PersonalStatus string `gorm:"default:" json:"status" validate:"max=200"`
+	Description    string `gorm:"default:" json:"descr" validate:"max=2000"`
 
 	LocationID uint      `gorm:"default:null" json:"-"`
 	Location   *Location `gorm:"constraint:OnDelete:SET NULL; default:null"`
+	Version    uint      `gorm:"not null;default:1" json:"version"` // incremented on every update of personal info or location
 }
 
 type User struct {
-	ID       uint   `gorm:"primaryKey"`
-	Login    string `gorm:"unique" json:"login,omitempty"`
-	Password string `json:"-,omitempty"`
+	ID           uint      `gorm:"primaryKey"`
+	Login        string    `gorm:"unique" json:"login,omitempty"`
+	Password     string    `json:"-,omitempty"`
+	TokenVersion uint      `gorm:"not null;default:1" json:"-"` // incremented to revoke all issued access tokens
+	CreatedAt    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index" json:"createdat"`
 
 	PersonalInfoID uint            `gorm:"default:null" json:"-"`
 	PersonalInfo   *PersonalInfo   `gorm:"constraint:OnDelete:SET NULL; default:null" json:"personal,omitempty"`
@@ -43,4 +50,124 @@ type User struct {
 	Article        []types.Article `gorm:"constraint:OnDelete:CASCADE;foreignKey:AuthorID" json:"-"`
 	Comment        []types.Comment `gorm:"constraint:OnDelete:CASCADE;foreignKey:AuthorID" json:"-"`
 	Like           []types.Like    `gorm:"constraint:OnDelete:CASCADE;foreignKey:UserID" json:"-"`
+	Session        []Session       `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	PasswordReset  []PasswordReset `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Verification   []Verification  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	TOTP           *TOTP           `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	RecoveryCode   []RecoveryCode  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	MFAChallenge   []MFAChallenge  `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	Identity       []Identity      `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+	APIToken       []APIToken      `gorm:"constraint:OnDelete:CASCADE;" json:"-"`
+}
+
+// Session stores one refresh token of the user. Every refresh rotates
+// the token and creates new session in the same family, so reuse of
+// already rotated token can be detected and whole family revoked
+type Session struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	FamilyID  string     `gorm:"not null;index"`
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	RevokedAt *time.Time `gorm:"default:null"`
+
+	// Device the session belongs to, StartedAt is the login time of the family
+	UserAgent  string    `gorm:"default:"`
+	IP         string    `gorm:"default:"`
+	StartedAt  time.Time `gorm:"default:null"`
+	LastSeenAt time.Time `gorm:"default:null"`
+}
+
+// PasswordReset stores hash of single-use token which allows
+// to set new password without knowing the current one
+type PasswordReset struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// Verification stores hash of single-use token which confirms that
+// the user owns Email. Token is valid only while user has this email
+type Verification struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	Email     string     `gorm:"not null"`
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// TOTP stores secret of the user's authenticator app. Secret is not used
+// for login until enrollment is confirmed with a valid code
+type TOTP struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;uniqueIndex"`
+	Secret       string    `gorm:"not null"`
+	Confirmed    bool      `gorm:"not null;default:false"`
+	LastUsedStep int64     `gorm:"not null;default:0"`
+	CreatedAt    time.Time `gorm:"not null"`
+}
+
+// RecoveryCode stores hash of single-use code which replaces TOTP code
+// when the user has no access to the authenticator app
+type RecoveryCode struct {
+	ID       uint       `gorm:"primaryKey"`
+	UserID   uint       `gorm:"not null;index"`
+	CodeHash string     `gorm:"not null;index"`
+	UsedAt   *time.Time `gorm:"default:null"`
+}
+
+// MFAChallenge is the first step of login for users with TOTP, its token
+// is exchanged together with TOTP code for access and refresh tokens
+type MFAChallenge struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"not null;uniqueIndex"`
+	Attempts  int        `gorm:"not null;default:0"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// Identity links the user to the account of external OpenID Connect
+// provider, Subject is the user's id in that provider
+type Identity struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	Provider  string    `gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject   string    `gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email     string    `gorm:"default:"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// OIDCState keeps PKCE verifier and nonce between redirect to the
+// provider and callback, it is found by hash of state parameter
+type OIDCState struct {
+	ID        uint       `gorm:"primaryKey"`
+	StateHash string     `gorm:"not null;uniqueIndex"`
+	Provider  string     `gorm:"not null"`
+	Verifier  string     `gorm:"not null"`
+	Nonce     string     `gorm:"not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+}
+
+// APIToken is personal access token for automation. It authorizes
+// requests like access token, but only with permissions from Scopes
+type APIToken struct {
+	ID         uint               `gorm:"primaryKey" json:"id"`
+	UserID     uint               `gorm:"not null;index" json:"-"`
+	Name       string             `gorm:"not null" json:"name"`
+	TokenHash  string             `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes     []types.Permission `gorm:"serializer:json" json:"scopes"`
+	ExpiresAt  time.Time          `gorm:"not null" json:"expiresat"`
+	CreatedAt  time.Time          `gorm:"not null" json:"createdat"`
+	LastUsedAt *time.Time         `gorm:"default:null" json:"lastusedat"`
+	RevokedAt  *time.Time         `gorm:"default:null" json:"-"`
 }